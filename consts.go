@@ -64,3 +64,62 @@ Permissions are handled on a per-key basis. The first user to create a key is
 considered the owner of that key; and your permissions to read, insert, update or
 delete data are based on whether you are the owner of the key.
 `
+
+const timeToLiveNote = `
+
+An optional time-to-live can be provided via the 'ttl' query parameter or the
+'X-TTL' header, either in seconds or as a duration such as '90s' or '2h'. Once
+expired, the key will no longer be retrievable and will be removed from the
+store. The remaining time-to-live is reported in the 'X-TTL' header when the
+key is retrieved.
+`
+
+const deliveryModeNote = `
+
+An optional delivery mode can be provided via the 'mode' query parameter or the
+'X-Delivery-Mode' header. Keys in the 'once' mode are removed from the store
+upon their first successful retrieval, so that only a single recipient can ever
+receive them; keys in the default 'persistent' mode are retained until deleted
+or expired.
+`
+
+const recipientsNote = `
+
+Optionally, the key can be addressed to specific users by providing their emails
+via the 'recipients' query parameter or the 'X-Recipients' header, separated by
+commas. Recipients can read and collect (delete) the key even if they are not
+otherwise permitted to; other users can still access it as their privileges allow,
+e.g. with the privilege to read all keys.
+`
+
+const ifMatchNote = `
+
+Every key carries a version, returned as the 'ETag' header when it is retrieved; the
+'ETag' also changes if the key is deleted and created again.
+Provide it in the 'If-Match' header to only modify the key if it had not been
+modified since; otherwise, 412 Precondition Failed is returned.
+`
+
+const waitNote = `
+
+Instead of polling, provide a 'wait' duration to park the request until the key
+is created, or until it no longer matches the 'If-None-Match' header. The wait is
+limited by the server timeout; if nothing changes in time, 404 Not Found or 304
+Not Modified is returned as usual.
+`
+
+const watchOverflowNote = `
+
+Events are buffered for each watcher; a watcher that falls too far behind will
+either miss events or be disconnected, depending on the server configuration.
+Disconnected watchers should reconnect, then use the <a href="/paths/keys/get">/keys</a>
+endpoint to catch up.
+`
+
+const historyNote = `
+
+Up to a configured number of previous versions are kept for each key; they are
+stored alongside the key, in the data file or the 'file' storage, and are removed
+along with the key. Versions that only changed the settings of a key, such as its
+access control list, share the value of the version after them.
+`
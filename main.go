@@ -48,10 +48,30 @@ func main() {
 		log.Printf("Starting PigeonHole service...\n")
 		log.Printf("Put operations will be limited to %v.\n", options.Timeout)
 
+		roles := users.DefaultRoles()
+		if options.Roles != "" {
+			var err error
+			if roles, err = users.LoadRoles(options.Roles); err != nil {
+				fmt.Println("Failed to load roles from file:", err)
+				os.Exit(1)
+			}
+		}
+		log.Printf("Users can be added with roles %v.\n", roles.Names())
+
+		hashAlgorithm, err := users.ParseHashAlgorithm(options.PasswordHash)
+		if err != nil {
+			fmt.Println("Unknown password hash algorithm:", options.PasswordHash)
+			os.Exit(1)
+		}
+
 		userOptions := users.UserOptions{
 			// TODO Move this into a configuration file
-			Salt:            options.Salt,
-			TokenExpiration: time.Hour * 2,
+			Salt:             options.Salt,
+			HashAlgorithm:    hashAlgorithm,
+			TokenExpiration:  options.TokenExpiration,
+			TokenMaxLifetime: options.TokenMaxLifetime,
+			PersistTokens:    options.PersistTokens,
+			Roles:            roles,
 		}
 
 		authManager, err := auth.ImportFromOrNew(options.UserList, "Global User List", userOptions)
@@ -61,6 +81,19 @@ func main() {
 			os.Exit(1)
 		}
 
+		limiterOptions := auth.LimiterOptions{
+			FreeAttempts:    options.LoginFreeAttempts,
+			BaseBackoff:     options.LoginBackoff,
+			MaxFailures:     options.LoginMaxFailures,
+			LockoutDuration: options.LoginLockout,
+			Window:          options.LoginWindow,
+		}
+		if err := limiterOptions.Validate(); err != nil {
+			fmt.Println("Invalid login limiter options:", err)
+			os.Exit(1)
+		}
+		authManager.Limiter = auth.NewLoginLimiter(limiterOptions)
+
 		api.UseMiddleware(interfaces.PassThroughRemoteHost)
 		api.UseMiddleware(interfaces.PassThroughAuthorizationToken(authManager))
 
@@ -81,18 +114,61 @@ func main() {
 			time.Second,
 			interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.AddUser)),
 		))
-		// `RemoveUser``
+		// Add the Group endpoints.
+		// These endpoints will only be accessible from the loopback address.
+
+		// `ListGroups`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/groups",
+			Summary:     "List groups",
+			Description: `List all the groups and the emails of their members.` + loopbackOnly,
+			Errors:      []int{200, 403},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.ListGroups)))
+		// `AddGroup`
+		huma.Register(api, huma.Operation{
+			Method:  http.MethodPut,
+			Path:    "/group",
+			Summary: "Add a new group",
+			Description: `Add a new group that users can be members of. Keys can be owned by a group in
+			addition to their owner, in which case all the members of the group are treated as owners.` + loopbackOnly,
+			Errors: []int{200, 400, 403},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.AddGroup)))
+		// `RemoveGroup`
 		huma.Register(api, huma.Operation{
 			Method:  http.MethodDelete,
-			Path:    "/user/{email}",
-			Summary: "Remove a user",
-			Description: `Remove a user from the system. The user will be removed from the system
-			based on the provided email address. This action is irreversible.` + loopbackOnly,
-			Errors: []int{200, 401, 403, 404, 500},
-		}, interfaces.MinimumTimeReturn(
-			time.Second,
-			interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.RemoveUser)),
-		))
+			Path:    "/group/{name}",
+			Summary: "Remove a group",
+			Description: `Remove a group along with all of its memberships. The keys owned by the group remain
+			owned by their individual owners; a new group with the same name does not own them.` + loopbackOnly,
+			Errors: []int{200, 403, 404},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.RemoveGroup)))
+		// `AddGroupMember`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodPut,
+			Path:        "/group/{name}/members/{email}",
+			Summary:     "Add a user to a group",
+			Description: `Add an existing user to a group; this takes effect immediately, including for their existing sessions.` + loopbackOnly,
+			Errors:      []int{200, 403, 404},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.AddGroupMember)))
+		// `RemoveGroupMember`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodDelete,
+			Path:        "/group/{name}/members/{email}",
+			Summary:     "Remove a user from a group",
+			Description: `Remove a user from a group; this takes effect immediately, including for their existing sessions.` + loopbackOnly,
+			Errors:      []int{200, 403, 404},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.RemoveGroupMember)))
+
+		// `ListRoles`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/roles",
+			Summary:     "List roles",
+			Description: `List the roles that users can be added with, and their privileges. Besides the built-in 'admin', 'standard' and 'restricted' roles, these are configured with the '--roles' option; roles with 'scopes' only have privileges on keys starting with one of those prefixes.` + loopbackOnly,
+			Errors:      []int{200, 403},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.ListRoles)))
+
 		// `LoginUser``
 		huma.Register(api, huma.Operation{
 			Method:  http.MethodPost,
@@ -105,11 +181,47 @@ func main() {
 				as: <pre>Bearer &lt;token&gt;</pre>`,
 				userOptions.TokenExpiration,
 			),
-			Errors: []int{200, 401, 500},
+			Errors: []int{200, 401, 429, 500},
 		}, interfaces.MinimumTimeReturn(
 			time.Second,
 			interfaces.UsesAuthManager(authManager, interfaces.LoginUser),
 		))
+		// `ListLockouts`
+		huma.Register(api, huma.Operation{
+			Method:  http.MethodGet,
+			Path:    "/lockouts",
+			Summary: "List lockouts",
+			Description: fmt.Sprintf(
+				`List the emails and IP addresses with failed logins. Logins are slowed down
+				exponentially from %v after %d consecutive failures, and locked out for %v after %d.`,
+				options.LoginBackoff,
+				options.LoginFreeAttempts,
+				options.LoginLockout,
+				options.LoginMaxFailures,
+			) + loopbackOnly,
+			Errors: []int{200, 403},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.ListLockouts)))
+		// `ClearLockouts`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodDelete,
+			Path:        "/lockouts",
+			Summary:     "Clear lockouts",
+			Description: `Clear the failed logins of an email and/or IP address, allowing them to login again immediately; all of them are cleared if neither is provided.` + loopbackOnly,
+			Errors:      []int{200, 400, 403},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.ClearLockouts)))
+		// `RefreshToken`
+		huma.Register(api, huma.Operation{
+			Method:  http.MethodPost,
+			Path:    "/token/refresh",
+			Summary: "Refresh token",
+			Description: fmt.Sprintf(
+				`Exchange the current token for a new one in the same session, which will expire after %v,
+				but never beyond the maximum lifetime of the session if there is one.
+				The current token must not have expired, and is revoked.`,
+				userOptions.TokenExpiration,
+			) + requiresBearerAuth,
+			Errors: []int{200, 401, 500},
+		}, interfaces.UsesAuthManager(authManager, interfaces.RefreshToken))
 		// `LogoutUser``
 		huma.Register(api, huma.Operation{
 			Method:  http.MethodPost,
@@ -129,7 +241,144 @@ func main() {
 			Errors:      []int{200, 401},
 		}, interfaces.UsesAuthManager(authManager, interfaces.GetUserPermission))
 
+		// `CreateAPIKey`
+		huma.Register(api, huma.Operation{
+			Method:  http.MethodPost,
+			Path:    "/user/apikeys",
+			Summary: "Create API key",
+			Description: `Create a named API key, which can be used in place of a token until it expires
+			or is revoked. The key can be restricted to a subset of the privileges of the user; it is only
+			returned once, so keep it safe. API keys cannot be used to manage API keys.` + requiresBearerAuth,
+			Errors: []int{200, 400, 401, 403, 409, 500},
+		}, interfaces.UsesAuthManager(authManager, interfaces.CreateAPIKey))
+		// `ListAPIKeys`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/user/apikeys",
+			Summary:     "List API keys",
+			Description: `List the API keys of the user, without the keys themselves.` + requiresBearerAuth,
+			Errors:      []int{200, 401, 403},
+		}, interfaces.UsesAuthManager(authManager, interfaces.ListAPIKeys))
+		// `RevokeAPIKey`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodDelete,
+			Path:        "/user/apikeys/{name}",
+			Summary:     "Revoke API key",
+			Description: `Revoke the API key with the name, which cannot be used any more.` + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404},
+		}, interfaces.UsesAuthManager(authManager, interfaces.RevokeAPIKey))
+
+		// `ListSessions`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/user/sessions",
+			Summary:     "List sessions",
+			Description: `List the unexpired sessions of the user, i.e. the tokens obtained by logging in, by their identifiers rather than the tokens themselves.` + requiresBearerAuth,
+			Errors:      []int{200, 401, 403},
+		}, interfaces.UsesAuthManager(authManager, interfaces.ListSessions))
+		// `RevokeSession`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodDelete,
+			Path:        "/user/sessions/{id}",
+			Summary:     "Revoke session",
+			Description: `Revoke a session of the user, logging it out.` + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404},
+		}, interfaces.UsesAuthManager(authManager, interfaces.RevokeSession))
+		// `ListUserSessions`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/user/{email}/sessions",
+			Summary:     "List sessions of a user",
+			Description: `List the unexpired sessions of any user.` + loopbackOnly,
+			Errors:      []int{200, 403, 404},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.ListUserSessions)))
+		// `RevokeUserSessions`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodDelete,
+			Path:        "/user/{email}/sessions",
+			Summary:     "Revoke sessions of a user",
+			Description: `Revoke all the sessions of any user, logging them out everywhere. Their API keys are not affected.` + loopbackOnly,
+			Errors:      []int{200, 403, 404},
+		}, interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManager(authManager, interfaces.RevokeUserSessions)))
+
 		kvc := keyValue.NewCache()
+		stopSnapshots := func() {}
+		if options.Storage == "file" {
+			if options.DataFile != "" || options.JournalFile != "" {
+				fmt.Println("The data file and journal are not used by the 'file' storage, which persists keys in the data directory.")
+				os.Exit(1)
+			}
+
+			backend, err := keyValue.NewFileBackend(options.DataDirectory)
+			if err != nil {
+				fmt.Println("Failed to open data directory:", err)
+				os.Exit(1)
+			}
+			if kvc, err = keyValue.NewCacheWithBackend(backend); err != nil {
+				fmt.Println("Failed to load keys from data directory:", err)
+				os.Exit(1)
+			}
+			log.Printf("Loaded %d keys from %s.\n", kvc.Length(), options.DataDirectory)
+		} else if options.Storage != "memory" {
+			fmt.Println("Unknown storage:", options.Storage)
+			os.Exit(1)
+		} else if options.DataFile != "" {
+			if kvc, err = keyValue.ImportFromOrNew(options.DataFile); err != nil {
+				fmt.Println("Failed to import key-value data from file:", err)
+				os.Exit(1)
+			}
+			log.Printf("Loaded %d keys from file.\n", kvc.Length())
+
+			if options.JournalFile != "" {
+				policy, err := keyValue.ParseSyncPolicy(options.JournalSync)
+				if err != nil {
+					fmt.Println("Unknown journal sync policy:", options.JournalSync)
+					os.Exit(1)
+				}
+				journal, records, err := keyValue.OpenJournal(options.JournalFile, options.DataFile, policy, options.JournalMaxSize)
+				if err != nil {
+					fmt.Println("Failed to open journal:", err)
+					os.Exit(1)
+				}
+				kvc.Replay(records)
+				log.Printf("Replayed %d journal records, %d keys are now loaded.\n", len(records), kvc.Length())
+				kvc.AttachJournal(journal)
+				stopSnapshots = kvc.StartJournal(options.SnapshotInterval, options.JournalSyncInterval)
+			} else {
+				stopSnapshots = kvc.StartSnapshots(options.DataFile, options.SnapshotInterval)
+			}
+		} else if options.JournalFile != "" {
+			fmt.Println("A data file is required for the journal to be compacted into.")
+			os.Exit(1)
+		}
+
+		if orphaned := kvc.ResolveOwners(authManager.GetUser); orphaned > 0 {
+			log.Printf("%d keys are owned by users that no longer exist.\n", orphaned)
+		}
+		stopSweeper := kvc.StartSweeper(options.SweepInterval)
+
+		overflow, err := keyValue.ParseOverflowPolicy(options.WatchOverflow)
+		if err != nil {
+			fmt.Println("Unknown watch overflow policy:", options.WatchOverflow)
+			os.Exit(1)
+		}
+		kvc.AttachEventBus(keyValue.NewEventBus(options.WatchBufferSize, overflow))
+		kvc.SetHistoryDepth(options.HistoryDepth)
+
+		// `RemoveUser``
+		// This is registered with the key-value endpoints, as it can transfer the keys of the user.
+		huma.Register(api, huma.Operation{
+			Method:  http.MethodDelete,
+			Path:    "/user/{email}",
+			Summary: "Remove a user",
+			Description: `Remove a user from the system. The user will be removed from the system
+			based on the provided email address. This action is irreversible. The keys owned by the user
+			are given to the user in "transferTo" if provided; if they cannot all be given, the user is not removed.` + loopbackOnly,
+			Errors: []int{200, 400, 401, 403, 404, 500},
+		}, interfaces.MinimumTimeReturn(
+			time.Second,
+			interfaces.MustBeCalledFromLoopBack(interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.RemoveUser)),
+		))
 		// Add the Key Value endpoints
 
 		// `GetKey``
@@ -137,16 +386,19 @@ func main() {
 			Method:      http.MethodGet,
 			Path:        "/key/{key}",
 			Summary:     "Get Data by Key",
-			Description: `Fetch bytes data by the provided key.` + userPermissionsNote + requiresBearerAuth,
-			Errors:      []int{200, 401, 403, 404},
-		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.GetKey))
+			Description: `Fetch bytes data by the provided key. Keys in the 'once' delivery mode will be removed upon retrieval. If the 'If-None-Match' header matches the 'ETag' of the key, 304 Not Modified is returned instead.` + waitNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 304, 400, 401, 403, 404},
+		}, interfaces.AllowsWaitingFor(
+			options.Timeout,
+			interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.GetKey)),
+		)
 		// `PatchKey`
 		huma.Register(api, huma.Operation{
 			Method:      http.MethodPatch,
 			Path:        "/key/{key}",
 			Summary:     "Update Data by Key",
-			Description: `Update bytes data by the provided key, only if the key already exists.` + userPermissionsNote + requiresBearerAuth,
-			Errors:      []int{200, 401, 403, 404, 504},
+			Description: `Update bytes data by the provided key, only if the key already exists.` + timeToLiveNote + deliveryModeNote + recipientsNote + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 400, 401, 403, 404, 412, 504},
 		}, interfaces.MaximumTimeReturn(
 			options.Timeout,
 			interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.PatchKey)),
@@ -156,8 +408,8 @@ func main() {
 			Method:      http.MethodPut,
 			Path:        "/key/{key}",
 			Summary:     "Add new Data by Key",
-			Description: `Add bytes data to a new key. This will only succeed if the key does not already exist.` + userPermissionsNote + requiresBearerAuth,
-			Errors:      []int{200, 401, 403, 408, 504},
+			Description: `Add bytes data to a new key. This will only succeed if the key does not already exist.` + timeToLiveNote + deliveryModeNote + recipientsNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 400, 401, 403, 408, 412, 504},
 		}, interfaces.MaximumTimeReturn(
 			options.Timeout,
 			interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.PutKey)),
@@ -167,8 +419,8 @@ func main() {
 			Method:      http.MethodPost,
 			Path:        "/key/{key}",
 			Summary:     "Add or update Data by Key",
-			Description: `Upsert bytes data by the provided key.` + userPermissionsNote + requiresBearerAuth,
-			Errors:      []int{200, 401, 403, 504},
+			Description: `Upsert bytes data by the provided key.` + timeToLiveNote + deliveryModeNote + recipientsNote + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 400, 401, 403, 412, 504},
 		}, interfaces.MaximumTimeReturn(
 			options.Timeout,
 			interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.PostKey)),
@@ -178,10 +430,97 @@ func main() {
 			Method:      http.MethodDelete,
 			Path:        "/key/{key}",
 			Summary:     "Delete Data by Key",
-			Description: `Delete the provided key from the cache.` + userPermissionsNote + requiresBearerAuth,
-			Errors:      []int{200, 401, 403, 404},
+			Description: `Delete the provided key from the cache.` + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404, 412},
 		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.DeleteKey))
 
+		// `SetKeyACL`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodPut,
+			Path:        "/key/{key}/acl",
+			Summary:     "Share a Key with specific Users",
+			Description: `Replace the access control list of the provided key, granting specific other users to read, update or delete it. The users are treated as the owner of the key for the granted operations, so a restricted user can be given access to a key without being promoted. This is permitted for the owner of the key, or users with the privilege to update all keys; the grants themselves do not allow changing the list.` + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 400, 401, 403, 404, 412},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.SetKeyACL))
+		// `TransferKey`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodPost,
+			Path:        "/key/{key}/owner",
+			Summary:     "Transfer a Key to another User",
+			Description: `Give the provided key to another existing user, who becomes its owner; its recipients and settings are retained. This is permitted for the current owner of the key, or users with the privilege to update all keys.` + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404, 412},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.TransferKey))
+		// `TransferKeys`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodPost,
+			Path:        "/keys/owner",
+			Summary:     "Transfer all Keys of a User to another User",
+			Description: `Give all the keys owned by the user 'from' to the existing user 'to'. The keys of an existing user are matched by their UUID, so keys left by an earlier user with the same email are not included; to recover the keys of a removed user, 'from' can be their UUID, or their email if no existing user has it. Users can only transfer their own keys, unless they have the privilege to update all keys.` + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404, 500},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.TransferKeys))
+		// `GetKeyMeta`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/key/{key}/meta",
+			Summary:     "Get the Metadata of a Key",
+			Description: `Retrieve the metadata of the provided key without its value, including when it was created, last modified and last read, and who last modified it. Unlike <a href="/paths/key-key/get">/key/{key}</a>, this does not deliver keys in the 'once' delivery mode.` + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.GetKeyMeta))
+		// `ListVersions`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/key/{key}/versions",
+			Summary:     "List Versions of a Key",
+			Description: `List the current and previous versions of the provided key, newest first and without their values. Use the 'version' query parameter of <a href="/paths/key-key/get">/key/{key}</a> to retrieve them.` + historyNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.ListVersions))
+		// `RestoreVersion`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodPost,
+			Path:        "/key/{key}/versions/{version}/restore",
+			Summary:     "Restore a previous Version of a Key",
+			Description: `Restore the value of a previous version of the provided key as its new version; this requires permission to update the key.` + historyNote + ifMatchNote + userPermissionsNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 403, 404, 412},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.RestoreVersion))
+
+		// `ListPigeonHole`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/pigeonhole",
+			Summary:     "List Keys addressed to the User",
+			Description: `List the keys addressed to the current user, without their values. Use the <a href="/paths/key-key/get">/key/{key}</a> endpoint to retrieve them.` + requiresBearerAuth,
+			Errors:      []int{200, 401},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.ListPigeonHole))
+
+		// `ListKeys`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/keys",
+			Summary:     "List Keys",
+			Description: `List the keys that the current user can retrieve, in lexical order and without their values. To fetch the next page, pass the <code>nextCursor</code> of the response as the <code>cursor</code>.` + requiresBearerAuth,
+			Errors:      []int{200, 401, 422},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.ListKeys))
+
+		// `WatchKeys`
+		huma.Register(api, huma.Operation{
+			Method:      http.MethodGet,
+			Path:        "/watch",
+			Summary:     "Watch Keys for Changes",
+			Description: `Stream the changes to the keys that the current user can retrieve as Server-Sent Events, without their values. Each event is named after the kind of change: 'created', 'updated', 'deleted', 'delivered' or 'expired'.` + watchOverflowNote + requiresBearerAuth,
+			Errors:      []int{200, 401, 422},
+			Responses: map[string]*huma.Response{
+				"200": {
+					Description: "A stream of Server-Sent Events, each containing the JSON metadata of the changed key.",
+					Content: map[string]*huma.MediaType{
+						"text/event-stream": {Schema: &huma.Schema{Type: huma.TypeString}},
+					},
+				},
+			},
+		}, interfaces.UsesAuthManagerAndKeyValueCache(authManager, &kvc, interfaces.WatchKeys))
+
+		// The WebSocket API is served outside of huma, as it is not a request-response endpoint.
+		router.Handle("/ws", interfaces.WebSocketHandler(authManager, &kvc))
+
 		server := http.Server{
 			Addr:    fmt.Sprintf("%s:%d", options.Host, options.Port),
 			Handler: router,
@@ -195,6 +534,16 @@ func main() {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 			server.Shutdown(ctx)
+			stopSweeper()
+			stopSnapshots()
+			if options.JournalFile != "" {
+				if err := kvc.Compact(); err != nil {
+					log.Printf("Failed to compact journal: %s\n", err)
+				}
+				defer kvc.CloseJournal()
+			} else if options.DataFile != "" {
+				defer kvc.ExportTo(options.DataFile)
+			}
 			defer authManager.ExportTo(options.UserList)
 		})
 	})
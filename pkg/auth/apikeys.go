@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/tokens"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// API keys start with this prefix, which tells them apart from the tokens of `TokenManager`.
+const API_KEY_PREFIX = "phk_"
+
+// Returns `true` if the bearer token is an API key rather than a token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, API_KEY_PREFIX)
+}
+
+// Create an API key for the user, returning the raw key along with how it is stored.
+//
+// The key is restricted to `privileges` if provided, on top of the privileges of the user; it
+// never expires if `expiry` is nil.
+func (ul *AuthManager) CreateAPIKey(
+	user *users.User,
+	name string,
+	expiry *time.Time,
+	privileges *users.Privileges,
+) (string, users.APIKey, error) {
+	secret, err := tokens.GenerateToken(tokens.TokenLength)
+	if err != nil {
+		return "", users.APIKey{}, err
+	}
+	key := API_KEY_PREFIX + secret
+
+	apiKey := users.APIKey{
+		Name:       name,
+		Hash:       tokens.HashToken(key),
+		Created:    time.Now().UTC(),
+		Expiry:     expiry,
+		Privileges: privileges,
+	}
+
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	current, err := ul.currentUser(user)
+	if err != nil {
+		return "", users.APIKey{}, err
+	} else if _, ok := current.GetAPIKey(name); ok {
+		return "", users.APIKey{}, errorMessages.ErrAPIKeyAlreadyExists
+	}
+
+	ul.updateUser(current, func(updated *users.User) {
+		updated.APIKeys = append(slices.Clone(updated.APIKeys), apiKey)
+	})
+
+	return key, apiKey, nil
+}
+
+// List the API keys of the user.
+func (ul *AuthManager) ListAPIKeys(user *users.User) ([]users.APIKey, error) {
+	ul.lock.RLock()
+	defer ul.lock.RUnlock()
+
+	current, err := ul.currentUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(current.APIKeys), nil
+}
+
+// Revoke the API key of the user with the name.
+func (ul *AuthManager) RevokeAPIKey(user *users.User, name string) error {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	current, err := ul.currentUser(user)
+	if err != nil {
+		return err
+	} else if _, ok := current.GetAPIKey(name); !ok {
+		return errorMessages.ErrAPIKeyNotFound
+	}
+
+	ul.updateUser(current, func(updated *users.User) {
+		updated.APIKeys = slices.DeleteFunc(slices.Clone(updated.APIKeys), func(apiKey users.APIKey) bool {
+			return apiKey.Name == name
+		})
+	})
+
+	return nil
+}
+
+// Authenticate a bearer token, which can be either a token of `TokenManager` or an API key,
+// returning the user it is for.
+//
+// Users authenticated by an API key are copies restricted to the privileges of the key.
+func (ul *AuthManager) Authenticate(token string) (*users.User, error) {
+	if !IsAPIKey(token) {
+		tokenData, err := ul.Tokens.GetToken(token)
+		if err != nil {
+			return nil, err
+		}
+		return tokenData.User, nil
+	}
+
+	return ul.authenticateAPIKey(token)
+}
+
+// Find the user that the token or the API key currently belongs to, as `Authenticate` does, but
+// without extending the expiry of the token; this is for checking on long-lived connections
+// that the user is still authenticated between their requests.
+func (ul *AuthManager) CurrentUser(token string) (*users.User, error) {
+	if !IsAPIKey(token) {
+		tokenData, err := ul.Tokens.LookupToken(token)
+		if err != nil {
+			return nil, err
+		}
+		return tokenData.User, nil
+	}
+
+	return ul.authenticateAPIKey(token)
+}
+
+// Find the user that the API key belongs to, restricted to the privileges of the key.
+//
+// This is called for every event of a subscription, so the user is found through the index of
+// API keys rather than by going through every user.
+func (ul *AuthManager) authenticateAPIKey(token string) (*users.User, error) {
+	ul.lock.RLock()
+	defer ul.lock.RUnlock()
+
+	hash := tokens.HashToken(token)
+	user, ok := ul.Users[ul.apiKeys[hash]]
+	if !ok {
+		return nil, errorMessages.ErrTokenInvalid
+	}
+
+	for _, apiKey := range user.APIKeys {
+		if apiKey.Hash != hash {
+			continue
+		} else if apiKey.IsExpired(time.Now()) {
+			return nil, errorMessages.ErrTokenExpired
+		}
+
+		authenticated := *user
+		authenticated.Restriction = apiKey.Privileges
+		return &authenticated, nil
+	}
+
+	return nil, errorMessages.ErrTokenInvalid
+}
+
+// Index the API keys of `user` in place of those of `previous`, either of which can be nil; the
+// caller must hold the lock for writing, or have the only reference to the list.
+func (ul *AuthManager) indexAPIKeys(previous *users.User, user *users.User) {
+	if ul.apiKeys == nil {
+		ul.apiKeys = make(map[string]string, 0)
+	}
+	if previous != nil {
+		for _, apiKey := range previous.APIKeys {
+			delete(ul.apiKeys, apiKey.Hash)
+		}
+	}
+	if user != nil {
+		for _, apiKey := range user.APIKeys {
+			ul.apiKeys[apiKey.Hash] = user.Email
+		}
+	}
+}
+
+// Find the user as currently in the list, which could have been replaced since the request
+// started; the caller must hold the lock.
+func (ul *AuthManager) currentUser(user *users.User) (*users.User, error) {
+	current, ok := ul.Users[user.Email]
+	if !ok || current.Uuid != user.Uuid {
+		return nil, errorMessages.ErrUserNotFound
+	}
+	return current, nil
+}
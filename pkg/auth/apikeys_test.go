@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+func TestAPIKeys(t *testing.T) {
+	am := NewAuthManager("Test", users.UserOptions{TokenExpiration: time.Hour})
+	user := users.NewUser("Steve", "steve@test.com", users.StandardUser())
+	am.AddUser(&user)
+
+	restriction := users.ReadOnlyUser()
+	key, apiKey, err := am.CreateAPIKey(&user, "ci", nil, &restriction)
+	if err != nil {
+		t.Fatalf("Expected no error creating an API key, got '%s'", err)
+	}
+	if !IsAPIKey(key) || apiKey.Hash == key {
+		t.Errorf("Expected a prefixed key stored by its hash, got '%s' and '%s'", key, apiKey.Hash)
+	}
+	if _, _, err := am.CreateAPIKey(&user, "ci", nil, nil); !errorMessages.Matches(err, errorMessages.ErrAPIKeyAlreadyExists) {
+		t.Errorf(`Expected "ErrAPIKeyAlreadyExists" error creating a duplicate API key, got '%s'`, err)
+	}
+
+	authenticated, err := am.Authenticate(key)
+	if err != nil {
+		t.Fatalf("Expected no error authenticating with the API key, got '%s'", err)
+	}
+	if authenticated.Uuid != user.Uuid || authenticated.Restriction == nil || authenticated.CanInsert("myKey", true) {
+		t.Errorf("Expected the user restricted to the privileges of the API key, got %+v", authenticated)
+	}
+	if stored, _ := am.GetUser(user.Email); stored.Restriction != nil {
+		t.Errorf("Expected the user in the list not to be restricted")
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, _ := am.CreateAPIKey(&user, "expired", &past, nil)
+	if _, err := am.Authenticate(expired); !errorMessages.Matches(err, errorMessages.ErrTokenExpired) {
+		t.Errorf(`Expected "ErrTokenExpired" error authenticating with an expired API key, got '%s'`, err)
+	}
+
+	if err := am.RevokeAPIKey(&user, "ci"); err != nil {
+		t.Errorf("Expected no error revoking the API key, got '%s'", err)
+	}
+	if _, err := am.Authenticate(key); !errorMessages.Matches(err, errorMessages.ErrTokenInvalid) {
+		t.Errorf(`Expected "ErrTokenInvalid" error authenticating with a revoked API key, got '%s'`, err)
+	}
+	if apiKeys, _ := am.ListAPIKeys(&user); len(apiKeys) != 1 || apiKeys[0].Name != "expired" {
+		t.Errorf("Expected only the expired API key to be left, got %+v", apiKeys)
+	}
+	if err := am.RevokeAPIKey(&user, "ci"); !errorMessages.Matches(err, errorMessages.ErrAPIKeyNotFound) {
+		t.Errorf(`Expected "ErrAPIKeyNotFound" error revoking the API key again, got '%s'`, err)
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// Group is a named set of users, which keys can be owned by.
+//
+// The members are not listed in the group; instead, each `users.User` lists the UUIDs of the
+// groups they are a member of, so that a group can be removed and recreated with the same name
+// without its new members inheriting the keys of the old group.
+type Group struct {
+	Uuid        uuid.UUID `json:"uuid" doc:"The unique identifier of the group."`
+	Name        string    `json:"name" doc:"The name of the group."`
+	Description string    `json:"description,omitempty" doc:"What the group is for."`
+}
+
+// NewGroup creates a new group with a new UUID.
+func NewGroup(name string, description string) Group {
+	return Group{
+		Uuid:        uuid.New(),
+		Name:        strings.ToLower(name),
+		Description: description,
+	}
+}
+
+// Replace the user with a modified copy, leaving the original untouched for any requests
+// still using it; the caller must hold the lock for writing.
+func (ul *AuthManager) updateUser(user *users.User, update func(*users.User)) *users.User {
+	updated := *user
+	update(&updated)
+
+	ul.Users[updated.Email] = &updated
+	ul.indexAPIKeys(user, &updated)
+	ul.Tokens.ReplaceUser(&updated)
+
+	return &updated
+}
+
+// Add the group to the list.
+func (ul *AuthManager) AddGroup(group *Group) (*Group, error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	if existing, ok := ul.Groups[group.Name]; ok {
+		return existing, errorMessages.ErrGroupAlreadyExists
+	}
+
+	ul.Groups[group.Name] = group
+
+	return group, nil
+}
+
+// Remove the group from the list, along with all of its memberships.
+func (ul *AuthManager) RemoveGroup(name string) (*Group, error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+	name = strings.ToLower(name)
+
+	group, ok := ul.Groups[name]
+	if !ok {
+		return &Group{}, errorMessages.ErrGroupNotFound
+	}
+
+	for _, user := range ul.Users {
+		if user.IsMemberOf(group.Uuid) {
+			ul.updateUser(user, func(user *users.User) {
+				user.Groups = slices.DeleteFunc(slices.Clone(user.Groups), func(id uuid.UUID) bool {
+					return id == group.Uuid
+				})
+			})
+		}
+	}
+	delete(ul.Groups, name)
+
+	return group, nil
+}
+
+// Get the group from the list.
+func (ul *AuthManager) GetGroup(name string) (*Group, error) {
+	ul.lock.RLock()
+	defer ul.lock.RUnlock()
+	name = strings.ToLower(name)
+
+	if group, ok := ul.Groups[name]; !ok {
+		return nil, errorMessages.ErrGroupNotFound
+	} else {
+		return group, nil
+	}
+}
+
+// List all the groups, sorted by name.
+func (ul *AuthManager) ListGroups() []*Group {
+	ul.lock.RLock()
+	defer ul.lock.RUnlock()
+
+	groups := make([]*Group, 0, len(ul.Groups))
+	for _, group := range ul.Groups {
+		groups = append(groups, group)
+	}
+	slices.SortFunc(groups, func(a, b *Group) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return groups
+}
+
+// List the emails of the members of the group, sorted.
+func (ul *AuthManager) Members(name string) ([]string, error) {
+	ul.lock.RLock()
+	defer ul.lock.RUnlock()
+	name = strings.ToLower(name)
+
+	group, ok := ul.Groups[name]
+	if !ok {
+		return nil, errorMessages.ErrGroupNotFound
+	}
+
+	members := make([]string, 0)
+	for email, user := range ul.Users {
+		if user.IsMemberOf(group.Uuid) {
+			members = append(members, email)
+		}
+	}
+	slices.Sort(members)
+
+	return members, nil
+}
+
+// Add the user to the group, returning the updated user.
+//
+// Adding a user who is already a member has no effect.
+func (ul *AuthManager) AddMember(name string, email string) (*users.User, error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	group, user, err := ul.membership(name, email)
+	if err != nil || user.IsMemberOf(group.Uuid) {
+		return user, err
+	}
+
+	return ul.updateUser(user, func(user *users.User) {
+		user.Groups = append(slices.Clone(user.Groups), group.Uuid)
+	}), nil
+}
+
+// Remove the user from the group, returning the updated user.
+//
+// Removing a user who is not a member has no effect.
+func (ul *AuthManager) RemoveMember(name string, email string) (*users.User, error) {
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	group, user, err := ul.membership(name, email)
+	if err != nil || !user.IsMemberOf(group.Uuid) {
+		return user, err
+	}
+
+	return ul.updateUser(user, func(user *users.User) {
+		user.Groups = slices.DeleteFunc(slices.Clone(user.Groups), func(id uuid.UUID) bool {
+			return id == group.Uuid
+		})
+	}), nil
+}
+
+// Find the group and the user of a membership; the caller must hold the lock.
+func (ul *AuthManager) membership(name string, email string) (*Group, *users.User, error) {
+	group, ok := ul.Groups[strings.ToLower(name)]
+	if !ok {
+		return nil, nil, errorMessages.ErrGroupNotFound
+	}
+
+	user, ok := ul.Users[strings.ToLower(email)]
+	if !ok {
+		return nil, nil, errorMessages.ErrUserNotFound
+	}
+
+	return group, user, nil
+}
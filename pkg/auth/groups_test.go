@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+func TestCurrentUserFollowsMemberships(t *testing.T) {
+	am := NewAuthManager("Test", users.UserOptions{TokenExpiration: time.Hour})
+	user := users.NewUser("Steve", "steve@test.com", users.StandardUser())
+	am.AddUser(&user)
+	group := NewGroup("backend", "")
+	am.AddGroup(&group)
+
+	tokenData, err := am.Tokens.CreateToken(&user, "")
+	if err != nil {
+		t.Fatalf("Expected no error creating a token, got '%s'", err)
+	}
+	if _, err := am.AddMember(group.Name, user.Email); err != nil {
+		t.Fatalf("Expected no error adding the member, got '%s'", err)
+	}
+	if current, _ := am.CurrentUser(tokenData.Token); !current.IsMemberOf(group.Uuid) {
+		t.Errorf("Expected the current user to be a member of the group")
+	}
+
+	// Long-lived connections should see the membership removed without logging in again
+	if _, err := am.RemoveMember(group.Name, user.Email); err != nil {
+		t.Fatalf("Expected no error removing the member, got '%s'", err)
+	}
+	if current, _ := am.CurrentUser(tokenData.Token); current.IsMemberOf(group.Uuid) {
+		t.Errorf("Expected the current user to no longer be a member of the group")
+	}
+
+	am.Tokens.DeleteToken(tokenData.Token)
+	if _, err := am.CurrentUser(tokenData.Token); !errorMessages.Matches(err, errorMessages.ErrTokenInvalid) {
+		t.Errorf(`Expected "ErrTokenInvalid" error for a deleted token, got '%s'`, err)
+	}
+}
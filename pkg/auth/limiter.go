@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// What a login attempt is tracked by.
+const (
+	LOCKOUT_BY_EMAIL = "email"
+	LOCKOUT_BY_IP    = "ip"
+)
+
+// LimiterOptions governs how failed logins are throttled.
+type LimiterOptions struct {
+	// The number of failures allowed before any backoff is applied.
+	FreeAttempts int
+	// The backoff after the first failure beyond the free attempts; this doubles with each further
+	// failure. There is no backoff before the lockout if this is 0.
+	BaseBackoff time.Duration
+	// The number of failures after which logins are locked out for `LockoutDuration`.
+	MaxFailures int
+	// How long logins are locked out for after `MaxFailures` failures.
+	LockoutDuration time.Duration
+	// How long failures are remembered for after the last one.
+	Window time.Duration
+}
+
+// DefaultLimiterOptions returns the options the limiter is created with, unless configured otherwise.
+func DefaultLimiterOptions() LimiterOptions {
+	return LimiterOptions{
+		FreeAttempts:    3,
+		BaseBackoff:     time.Second,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+}
+
+// Check that none of the options are negative, and that the free attempts do not exceed the
+// failures before a lockout.
+func (o LimiterOptions) Validate() error {
+	switch {
+	case o.FreeAttempts < 0:
+		return fmt.Errorf("%w: the free attempts must not be negative", errorMessages.ErrInvalidLimiterOptions)
+	case o.MaxFailures < 0:
+		return fmt.Errorf("%w: the maximum failures must not be negative", errorMessages.ErrInvalidLimiterOptions)
+	case o.BaseBackoff < 0:
+		return fmt.Errorf("%w: the backoff must not be negative", errorMessages.ErrInvalidLimiterOptions)
+	case o.LockoutDuration < 0:
+		return fmt.Errorf("%w: the lockout duration must not be negative", errorMessages.ErrInvalidLimiterOptions)
+	case o.Window < 0:
+		return fmt.Errorf("%w: the window must not be negative", errorMessages.ErrInvalidLimiterOptions)
+	case o.FreeAttempts > o.MaxFailures:
+		return fmt.Errorf("%w: the free attempts must not exceed the maximum failures", errorMessages.ErrInvalidLimiterOptions)
+	}
+
+	return nil
+}
+
+// Lockout is the record of the failed logins by an email or an IP address.
+type Lockout struct {
+	By          string    `json:"by" enum:"email,ip" doc:"Whether the failures are tracked by email or by IP address."`
+	Subject     string    `json:"subject" doc:"The email or the IP address the failures are tracked by."`
+	Failures    int       `json:"failures" doc:"The number of consecutive failed logins."`
+	LastFailure time.Time `json:"lastFailure" doc:"The time of the last failed login."`
+	LockedUntil time.Time `json:"lockedUntil" doc:"The time until which logins are refused."`
+
+	// The number of attempts allowed by `Check` which have yet to be settled.
+	pending int
+}
+
+// Returns `true` if logins are refused at the time.
+func (l *Lockout) isLocked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+// Returns `true` if the record can be forgotten without losing any failures or pending attempts.
+func (l *Lockout) isEmpty() bool {
+	return l.Failures == 0 && l.pending == 0
+}
+
+// LoginLimiter tracks failed logins by email and by IP address, refusing further logins with an
+// exponential backoff, and locking them out after too many failures.
+//
+// Failures by IP address are not cleared by a successful login, so that an attacker cannot
+// reset them by logging in to an account of their own between guesses. Failed logins for emails
+// without a user are only tracked by IP address, so that guessing emails cannot grow the records
+// without bound.
+//
+// Each attempt allowed by `Check` is reserved until it is settled by `Fail`, `FailUnknown` or
+// `Succeed`; attempts in flight count towards the backoff, so that parallel logins cannot get
+// around it.
+type LoginLimiter struct {
+	lockouts map[string]*Lockout
+	options  LimiterOptions
+	lock     sync.Mutex
+}
+
+// NewLoginLimiter creates a new LoginLimiter without any failures.
+func NewLoginLimiter(options LimiterOptions) *LoginLimiter {
+	return &LoginLimiter{
+		lockouts: make(map[string]*Lockout, 0),
+		options:  options,
+	}
+}
+
+// The subjects of a login attempt, by which it is tracked.
+func loginSubjects(email string, ip net.IP) [][2]string {
+	subjects := [][2]string{{LOCKOUT_BY_EMAIL, strings.ToLower(email)}}
+	if ip != nil {
+		subjects = append(subjects, [2]string{LOCKOUT_BY_IP, ip.String()})
+	}
+	return subjects
+}
+
+// Check if a login is allowed for the email from the IP address; if not, returns the time after
+// which it would be allowed.
+//
+// If allowed, the attempt is reserved, and must be settled by exactly one of `Fail`, `FailUnknown`
+// or `Succeed`. The IP address can be `nil` if it is not known, in which case only the email is
+// checked.
+func (ll *LoginLimiter) Check(email string, ip net.IP) (time.Time, error) {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	now := time.Now().UTC()
+	ll.prune(now)
+
+	subjects := loginSubjects(email, ip)
+	var retryAt time.Time
+	for _, subject := range subjects {
+		lockout, ok := ll.lockouts[subject[0]+":"+subject[1]]
+		if !ok {
+			continue
+		}
+
+		if lockout.isLocked(now) && lockout.LockedUntil.After(retryAt) {
+			retryAt = lockout.LockedUntil
+		}
+		// Should the attempts in flight fail, they would lock out further attempts.
+		if delay := ll.delayAfter(lockout.Failures + lockout.pending); lockout.pending > 0 && delay > 0 && now.Add(delay).After(retryAt) {
+			retryAt = now.Add(delay)
+		}
+	}
+
+	if !retryAt.IsZero() {
+		return retryAt, errorMessages.ErrTooManyAttempts
+	}
+
+	for _, subject := range subjects {
+		ll.lockoutOf(subject).pending++
+	}
+	return retryAt, nil
+}
+
+// Record a failed login for the email from the IP address, settling the attempt reserved by
+// `Check` and returning the time until which further logins are refused, if any.
+func (ll *LoginLimiter) Fail(email string, ip net.IP) time.Time {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	return ll.fail(loginSubjects(email, ip), time.Now().UTC())
+}
+
+// Record a failed login for an email without a user from the IP address, settling the attempt
+// reserved by `Check`; the failure is only tracked by the IP address.
+func (ll *LoginLimiter) FailUnknown(email string, ip net.IP) time.Time {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	subjects := loginSubjects(email, ip)
+	ll.settle(subjects[0])
+	return ll.fail(subjects[1:], time.Now().UTC())
+}
+
+// Record a successful login for the email from the IP address, settling the attempt reserved by
+// `Check` and clearing the failures of the email.
+func (ll *LoginLimiter) Succeed(email string, ip net.IP) {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	subjects := loginSubjects(email, ip)
+	for _, subject := range subjects[1:] {
+		ll.settle(subject)
+	}
+
+	// Other attempts for the email may still be in flight.
+	if lockout, ok := ll.lockouts[subjects[0][0]+":"+subjects[0][1]]; ok {
+		lockout.Failures = 0
+		lockout.LockedUntil = time.Time{}
+		ll.settle(subjects[0])
+	}
+}
+
+// Get the record of a subject, creating it if there is none; the caller must hold the lock.
+func (ll *LoginLimiter) lockoutOf(subject [2]string) *Lockout {
+	key := subject[0] + ":" + subject[1]
+	lockout, ok := ll.lockouts[key]
+	if !ok {
+		lockout = &Lockout{By: subject[0], Subject: subject[1]}
+		ll.lockouts[key] = lockout
+	}
+	return lockout
+}
+
+// Release the attempt reserved for a subject, forgetting its record if nothing is left in it;
+// the caller must hold the lock.
+func (ll *LoginLimiter) settle(subject [2]string) {
+	key := subject[0] + ":" + subject[1]
+	if lockout, ok := ll.lockouts[key]; ok {
+		if lockout.pending > 0 {
+			lockout.pending--
+		}
+		if lockout.isEmpty() {
+			delete(ll.lockouts, key)
+		}
+	}
+}
+
+// Record a failure against the subjects, settling their reserved attempts; the caller must hold
+// the lock.
+func (ll *LoginLimiter) fail(subjects [][2]string, now time.Time) time.Time {
+	var lockedUntil time.Time
+	for _, subject := range subjects {
+		lockout := ll.lockoutOf(subject)
+		if lockout.pending > 0 {
+			lockout.pending--
+		}
+
+		lockout.Failures++
+		lockout.LastFailure = now
+		if delay := ll.delayAfter(lockout.Failures); delay > 0 {
+			lockout.LockedUntil = now.Add(delay)
+		}
+
+		if lockout.LockedUntil.After(lockedUntil) {
+			lockedUntil = lockout.LockedUntil
+		}
+	}
+
+	return lockedUntil
+}
+
+// How long logins are refused for after the number of consecutive failures.
+func (ll *LoginLimiter) delayAfter(failures int) time.Duration {
+	if failures >= ll.options.MaxFailures {
+		return ll.options.LockoutDuration
+	} else if failures <= ll.options.FreeAttempts || ll.options.BaseBackoff <= 0 {
+		return 0
+	}
+
+	// The backoff only becomes non-positive if doubling it overflows.
+	delay := ll.options.BaseBackoff << (failures - ll.options.FreeAttempts - 1)
+	if delay <= 0 || delay > ll.options.LockoutDuration {
+		return ll.options.LockoutDuration
+	}
+	return delay
+}
+
+// Forget the failures that are no longer locked out and are older than the window, unless an
+// attempt is still pending; the caller must hold the lock.
+func (ll *LoginLimiter) prune(now time.Time) {
+	for key, lockout := range ll.lockouts {
+		if lockout.pending == 0 && !lockout.isLocked(now) && now.Sub(lockout.LastFailure) > ll.options.Window {
+			delete(ll.lockouts, key)
+		}
+	}
+}
+
+// List the emails and IP addresses with failed logins, most recent failure first.
+func (ll *LoginLimiter) Lockouts() []Lockout {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	ll.prune(time.Now().UTC())
+
+	lockouts := make([]Lockout, 0, len(ll.lockouts))
+	for _, lockout := range ll.lockouts {
+		// Attempts in flight without any failure are not worth listing.
+		if lockout.Failures > 0 {
+			lockouts = append(lockouts, *lockout)
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+
+	return lockouts
+}
+
+// Clear the failed logins of an email or IP address, returning the number of records cleared.
+//
+// If `subject` is empty, all the records tracked by `by` are cleared; if `by` is also empty,
+// all the records are cleared.
+func (ll *LoginLimiter) Clear(by string, subject string) int {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	if by == LOCKOUT_BY_EMAIL {
+		subject = strings.ToLower(subject)
+	} else if ip := net.ParseIP(subject); ip != nil {
+		subject = ip.String()
+	}
+
+	cleared := 0
+	for key, lockout := range ll.lockouts {
+		if (by == "" || lockout.By == by) && (subject == "" || lockout.Subject == subject) {
+			delete(ll.lockouts, key)
+			cleared++
+		}
+	}
+
+	return cleared
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	limiter := NewLoginLimiter(LimiterOptions{
+		FreeAttempts:    2,
+		BaseBackoff:     time.Minute,
+		MaxFailures:     5,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	})
+	ip := net.ParseIP("192.0.2.1")
+
+	for i := 0; i < 2; i++ {
+		if lockedUntil := limiter.Fail("steve@test.com", ip); !lockedUntil.IsZero() {
+			t.Errorf("Expected no backoff within the free attempts, got %s", lockedUntil)
+		}
+	}
+	if _, err := limiter.Check("steve@test.com", ip); err != nil {
+		t.Errorf("Expected no error checking within the free attempts, got '%s'", err)
+	}
+
+	// The backoff doubles with each failure.
+	first := limiter.Fail("steve@test.com", ip)
+	second := limiter.Fail("steve@test.com", ip)
+	if delay := time.Until(first); delay < 59*time.Second || delay > time.Minute {
+		t.Errorf("Expected a backoff of a minute, got %s", delay)
+	}
+	if delay := time.Until(second); delay < 119*time.Second || delay > 2*time.Minute {
+		t.Errorf("Expected a backoff of two minutes, got %s", delay)
+	}
+	if _, err := limiter.Check("STEVE@test.com", nil); !errorMessages.Matches(err, errorMessages.ErrTooManyAttempts) {
+		t.Errorf(`Expected "ErrTooManyAttempts" error checking the email, got '%s'`, err)
+	}
+	if _, err := limiter.Check("alice@test.com", ip); !errorMessages.Matches(err, errorMessages.ErrTooManyAttempts) {
+		t.Errorf(`Expected "ErrTooManyAttempts" error checking the IP address, got '%s'`, err)
+	}
+	if _, err := limiter.Check("alice@test.com", net.ParseIP("192.0.2.2")); err != nil {
+		t.Errorf("Expected no error checking another email and IP address, got '%s'", err)
+	}
+
+	// Too many failures lock out for the whole duration.
+	if delay := time.Until(limiter.Fail("steve@test.com", ip)); delay < 59*time.Minute {
+		t.Errorf("Expected a lockout of an hour, got %s", delay)
+	}
+	if lockouts := limiter.Lockouts(); len(lockouts) != 2 || lockouts[0].Failures != 5 {
+		t.Errorf("Expected 2 lockouts with 5 failures, got %+v", lockouts)
+	}
+
+	// Logging in successfully clears the email, but not the IP address.
+	limiter.Succeed("steve@test.com", nil)
+	if _, err := limiter.Check("steve@test.com", nil); err != nil {
+		t.Errorf("Expected no error checking the email after logging in, got '%s'", err)
+	}
+	if _, err := limiter.Check("steve@test.com", ip); !errorMessages.Matches(err, errorMessages.ErrTooManyAttempts) {
+		t.Errorf(`Expected "ErrTooManyAttempts" error checking the IP address after logging in, got '%s'`, err)
+	}
+
+	if cleared := limiter.Clear(LOCKOUT_BY_IP, "192.0.2.1"); cleared != 1 {
+		t.Errorf("Expected 1 lockout to be cleared, got %d", cleared)
+	}
+	if _, err := limiter.Check("steve@test.com", ip); err != nil {
+		t.Errorf("Expected no error checking after clearing, got '%s'", err)
+	}
+}
+
+func TestLoginLimiterReservesAttempts(t *testing.T) {
+	limiter := NewLoginLimiter(LimiterOptions{
+		FreeAttempts:    2,
+		BaseBackoff:     time.Minute,
+		MaxFailures:     5,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	})
+	ip := net.ParseIP("192.0.2.1")
+
+	// Attempts in flight count towards the backoff before any of them has failed.
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Check("steve@test.com", ip); err != nil {
+			t.Errorf("Expected no error checking within the free attempts, got '%s'", err)
+		}
+	}
+	if _, err := limiter.Check("steve@test.com", ip); err != nil {
+		t.Errorf("Expected no error checking the first attempt beyond the free attempts, got '%s'", err)
+	}
+	if _, err := limiter.Check("steve@test.com", ip); !errorMessages.Matches(err, errorMessages.ErrTooManyAttempts) {
+		t.Errorf(`Expected "ErrTooManyAttempts" error checking with attempts in flight, got '%s'`, err)
+	}
+
+	// Settling the attempts releases them.
+	limiter.Succeed("steve@test.com", ip)
+	limiter.Succeed("steve@test.com", ip)
+	limiter.Succeed("steve@test.com", ip)
+	if _, err := limiter.Check("steve@test.com", ip); err != nil {
+		t.Errorf("Expected no error checking after the attempts were settled, got '%s'", err)
+	}
+	limiter.Succeed("steve@test.com", ip)
+	if lockouts := limiter.Lockouts(); len(lockouts) != 0 {
+		t.Errorf("Expected no lockouts after successful logins, got %+v", lockouts)
+	}
+
+	// Failures for emails without a user are only tracked by IP address.
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Check("nobody@test.com", ip); err != nil {
+			t.Errorf("Expected no error checking an unknown email, got '%s'", err)
+		}
+		limiter.FailUnknown("nobody@test.com", ip)
+	}
+	if lockouts := limiter.Lockouts(); len(lockouts) != 1 || lockouts[0].By != LOCKOUT_BY_IP || lockouts[0].Failures != 2 {
+		t.Errorf("Expected 1 lockout by IP address with 2 failures, got %+v", lockouts)
+	}
+}
+
+func TestLoginLimiterWithoutBackoff(t *testing.T) {
+	limiter := NewLoginLimiter(LimiterOptions{
+		FreeAttempts:    1,
+		BaseBackoff:     0,
+		MaxFailures:     3,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	})
+
+	// Without a backoff, only reaching the maximum failures locks out.
+	for i := 0; i < 2; i++ {
+		if lockedUntil := limiter.Fail("steve@test.com", nil); !lockedUntil.IsZero() {
+			t.Errorf("Expected no backoff after %d failures, got %s", i+1, lockedUntil)
+		}
+	}
+	if delay := time.Until(limiter.Fail("steve@test.com", nil)); delay < 59*time.Minute {
+		t.Errorf("Expected a lockout of an hour, got %s", delay)
+	}
+}
+
+func TestLimiterOptionsValidate(t *testing.T) {
+	if err := DefaultLimiterOptions().Validate(); err != nil {
+		t.Errorf("Expected the default options to be valid, got '%s'", err)
+	}
+
+	invalid := []func(*LimiterOptions){
+		func(o *LimiterOptions) { o.FreeAttempts = -1 },
+		func(o *LimiterOptions) { o.MaxFailures = -1 },
+		func(o *LimiterOptions) { o.BaseBackoff = -time.Second },
+		func(o *LimiterOptions) { o.LockoutDuration = -time.Second },
+		func(o *LimiterOptions) { o.Window = -time.Second },
+		func(o *LimiterOptions) { o.FreeAttempts = o.MaxFailures + 1 },
+	}
+	for i, modify := range invalid {
+		options := DefaultLimiterOptions()
+		modify(&options)
+		if err := options.Validate(); !errorMessages.Matches(err, errorMessages.ErrInvalidLimiterOptions) {
+			t.Errorf(`Expected "ErrInvalidLimiterOptions" error for options %d, got '%v'`, i, err)
+		}
+	}
+}
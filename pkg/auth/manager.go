@@ -9,6 +9,8 @@ import (
 
 	"sync"
 
+	"github.com/google/uuid"
+
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 	"github.com/denwong47/pigeon-hole/pkg/tokens"
 	"github.com/denwong47/pigeon-hole/pkg/users"
@@ -19,9 +21,21 @@ type AuthManager struct {
 	Name      string                 `json:"name" doc:"The name of the list."`
 	Timestamp time.Time              `json:"timestamp" doc:"The time this list was updated."`
 	Users     map[string]*users.User `json:"users" doc:"The list of users."`
+	Groups    map[string]*Group      `json:"groups" doc:"The groups that users can be members of, by name."`
 	Tokens    tokens.TokenManager    `json:"-"`
+	Limiter   *LoginLimiter          `json:"-"`
 	Options   users.UserOptions      `json:"-"`
 	lock      sync.RWMutex
+
+	// The emails of the users by the hashes of their API keys, so that API keys can be
+	// authenticated without going through every user; see `indexAPIKeys`.
+	apiKeys map[string]string
+}
+
+// The user list as written to the file, along with the tokens if they are persisted.
+type savedAuthManager struct {
+	*AuthManager
+	Tokens []tokens.SavedToken `json:"tokens,omitempty" doc:"The unexpired tokens, by their hashes."`
 }
 
 // NewAuthManger creates a new AuthManager with an empty list of users.
@@ -30,11 +44,22 @@ func NewAuthManager(name string, options users.UserOptions) *AuthManager {
 		Name:      name,
 		Timestamp: time.Now().UTC(),
 		Users:     make(map[string]*users.User, 0),
-		Tokens:    tokens.NewTokenManager(options.TokenExpiration),
+		Groups:    make(map[string]*Group, 0),
+		Tokens:    tokens.NewTokenManager(options.TokenExpiration, options.TokenMaxLifetime),
+		Limiter:   NewLoginLimiter(DefaultLimiterOptions()),
 		Options:   options,
+		apiKeys:   make(map[string]string, 0),
 	}
 }
 
+// Roles returns the roles that users can be added with; the built-in roles if none were configured.
+func (ul *AuthManager) Roles() users.Roles {
+	if ul.Options.Roles == nil {
+		return users.DefaultRoles()
+	}
+	return ul.Options.Roles
+}
+
 // UpdateTimestamp updates the timestamp of the AuthManager.
 func (ul *AuthManager) UpdateTimestamp() {
 	ul.Timestamp = time.Now().UTC()
@@ -54,16 +79,31 @@ func ImportFrom(path string, options users.UserOptions) (*AuthManager, error) {
 	}
 
 	var ul AuthManager
-	if err := json.Unmarshal(buffer, &ul); err != nil {
+	saved := savedAuthManager{AuthManager: &ul}
+	if err := json.Unmarshal(buffer, &saved); err != nil {
 		return &AuthManager{}, err
 	}
 
+	// User lists written before groups were introduced will not have any.
+	if ul.Groups == nil {
+		ul.Groups = make(map[string]*Group, 0)
+	}
 	// Reinitialize the options, which would not be serialized.
 	ul.Options = options
 	// Reinitialize the lock, which would not be serialized.
 	ul.lock = sync.RWMutex{}
+	// Index the API keys, which are only stored with their users.
+	for _, user := range ul.Users {
+		ul.indexAPIKeys(nil, user)
+	}
 	// Reinitialize the token manager, which would not be serialized.
-	ul.Tokens = tokens.NewTokenManager(ul.Options.TokenExpiration)
+	ul.Tokens = tokens.NewTokenManager(ul.Options.TokenExpiration, ul.Options.TokenMaxLifetime)
+	if ul.Options.PersistTokens {
+		restored := ul.Tokens.Restore(saved.Tokens, ul.userByUuid)
+		log.Printf("Restored %d of %d saved tokens.\n", restored, len(saved.Tokens))
+	}
+	// Failed logins are not persisted either.
+	ul.Limiter = NewLoginLimiter(DefaultLimiterOptions())
 
 	return &ul, nil
 }
@@ -91,7 +131,12 @@ func (ul *AuthManager) ExportTo(path string) error {
 
 	ul.UpdateTimestamp()
 
-	buffer, err := json.MarshalIndent(ul, "", "  ")
+	saved := savedAuthManager{AuthManager: ul}
+	if ul.Options.PersistTokens {
+		saved.Tokens = ul.Tokens.Save()
+	}
+
+	buffer, err := json.MarshalIndent(saved, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -113,6 +158,7 @@ func (ul *AuthManager) AddUser(user *users.User) (*users.User, error) {
 	}
 
 	ul.Users[user.Email] = user
+	ul.indexAPIKeys(nil, user)
 
 	return user, nil
 }
@@ -130,6 +176,7 @@ func (ul *AuthManager) RemoveUser(email string) (*users.User, error) {
 	}
 
 	delete(ul.Users, email)
+	ul.indexAPIKeys(user, nil)
 
 	return user, nil
 }
@@ -146,3 +193,42 @@ func (ul *AuthManager) GetUser(email string) (*users.User, error) {
 		return user, nil
 	}
 }
+
+// Find the user with the UUID; the caller must hold the lock, or have the only reference to the list.
+func (ul *AuthManager) userByUuid(uuid uuid.UUID) (*users.User, bool) {
+	for _, user := range ul.Users {
+		if user.Uuid == uuid {
+			return user, true
+		}
+	}
+	return nil, false
+}
+
+// Hash the password of the user again with the current algorithm, if it is not already, returning
+// the updated user.
+//
+// This is meant to be called after a successful login, as the password is only known then; the
+// new hash will be written to the user list on the next `ExportTo`.
+func (ul *AuthManager) RehashPassword(user *users.User, password string) (*users.User, error) {
+	if !user.NeedsRehash(&ul.Options) {
+		return user, nil
+	}
+
+	rehashed, err := user.SetPassword(password, &ul.Options)
+	if err != nil {
+		return user, err
+	}
+
+	ul.lock.Lock()
+	defer ul.lock.Unlock()
+
+	// The user could have been removed or replaced since they logged in.
+	current, err := ul.currentUser(user)
+	if err != nil {
+		return user, err
+	}
+
+	return ul.updateUser(current, func(updated *users.User) {
+		updated.HashedPass = rehashed.HashedPass
+	}), nil
+}
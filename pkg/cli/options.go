@@ -4,9 +4,31 @@ import "time"
 
 // Options for the CLI.
 type Options struct {
-	Host     string        `doc:"Host to listen on" format:"ipv4" default:"0.0.0.0"`
-	Port     int           `doc:"Port to listen on" short:"p" default:"8888"`
-	Salt     string        `doc:"Salt for hashing passwords. This is not hard coded anywhere, as they can make cracking the stored hashes easier. Provide this at runtime to minimise the chance of attack" default:""`
-	UserList string        `doc:"Path to the user list file" default:"./users.json"`
-	Timeout  time.Duration `doc:"Timeout for requests in seconds" default:"15s"`
+	Host                string        `doc:"Host to listen on" format:"ipv4" default:"0.0.0.0"`
+	Port                int           `doc:"Port to listen on" short:"p" default:"8888"`
+	Salt                string        `doc:"Salt for checking passwords hashed with SHA-256 by older versions, which are hashed again on the next login. This is not hard coded anywhere, as they can make cracking the stored hashes easier. Provide this at runtime to minimise the chance of attack" default:""`
+	PasswordHash        string        `doc:"Algorithm for hashing passwords: 'argon2id' or 'bcrypt'" default:"argon2id"`
+	UserList            string        `doc:"Path to the user list file" default:"./users.json"`
+	Roles               string        `doc:"Path to a JSON file of the roles users can be added with, in addition to 'admin', 'standard' and 'restricted'" default:""`
+	TokenExpiration     time.Duration `doc:"How long tokens are valid for after they were created, or last used if their expiry slides" default:"2h"`
+	TokenMaxLifetime    time.Duration `doc:"Slide the expiry of tokens each time they are used, but never beyond this long after they were created; the expiry does not slide if this is 0" default:"0s"`
+	PersistTokens       bool          `doc:"Write the hashes of unexpired tokens to the user list, so that users stay logged in across restarts" default:"false"`
+	LoginFreeAttempts   int           `doc:"Number of consecutive failed logins by an email or IP address before further logins are slowed down exponentially" default:"3"`
+	LoginBackoff        time.Duration `doc:"How long logins are refused for after the first failure beyond the free attempts; this doubles with each further failure, and there is no backoff before the lockout if this is 0" default:"1s"`
+	LoginMaxFailures    int           `doc:"Number of consecutive failed logins by an email or IP address before further logins are locked out" default:"10"`
+	LoginLockout        time.Duration `doc:"How long logins are locked out for after too many failures" default:"15m"`
+	LoginWindow         time.Duration `doc:"How long failed logins are remembered for after the last one" default:"1h"`
+	Timeout             time.Duration `doc:"Timeout for requests in seconds" default:"15s"`
+	SweepInterval       time.Duration `doc:"Interval between sweeps of expired keys" default:"30s"`
+	HistoryDepth        int           `doc:"Number of previous versions kept for each key" default:"10"`
+	Storage             string        `doc:"Where to keep the keys: 'memory', or 'file' for one file per key in the data directory" default:"memory"`
+	DataDirectory       string        `doc:"Directory to keep the keys in, if the 'file' storage is used" default:"./data"`
+	DataFile            string        `doc:"Path to the key-value data file; keys will only be kept in memory if not provided" default:""`
+	SnapshotInterval    time.Duration `doc:"Interval between snapshots of the key-value data to the data file" default:"5m"`
+	JournalFile         string        `doc:"Path to the write-ahead journal of key-value mutations; requires a data file" default:""`
+	JournalSync         string        `doc:"When to flush the journal to disk: 'always', 'interval' or 'never'" default:"interval"`
+	JournalSyncInterval time.Duration `doc:"Interval between flushes of the journal to disk, if the 'interval' policy is used" default:"1s"`
+	JournalMaxSize      int64         `doc:"Size in bytes beyond which the journal will be compacted into the data file" default:"67108864"`
+	WatchBufferSize     int           `doc:"Number of events buffered for each watcher of key changes" default:"64"`
+	WatchOverflow       string        `doc:"What to do with watchers that fall behind by more than the buffer: 'drop' their events, or 'disconnect' them" default:"disconnect"`
 }
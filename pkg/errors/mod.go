@@ -15,20 +15,42 @@ var ErrRemoteHostForbidden = errors.New("RemoteHostForbidden")
 var ErrUserAlreadyExists = errors.New("UserAlreadyExists")
 var ErrUserNotFound = errors.New("UserNotFound")
 var ErrAuthenticationFailed = errors.New("AuthenticationFailed")
+var ErrTooManyAttempts = errors.New("TooManyAttempts")
+var ErrInvalidLimiterOptions = errors.New("InvalidLimiterOptions")
 var ErrNotPermitted = errors.New("NotPermitted")
 
 var ErrUserFileNotFound = errors.New("UserFileNotFound")
+var ErrDataFileNotFound = errors.New("DataFileNotFound")
+var ErrJournalWrite = errors.New("JournalWrite")
+var ErrNoJournal = errors.New("NoJournal")
+var ErrUnknownSyncPolicy = errors.New("UnknownSyncPolicy")
+var ErrUnknownOverflowPolicy = errors.New("UnknownOverflowPolicy")
 
 var ErrUnknownUserType = errors.New("UnknownUserType")
+var ErrRoleFileNotFound = errors.New("RoleFileNotFound")
+var ErrInvalidRole = errors.New("InvalidRole")
+var ErrUnknownHashAlgorithm = errors.New("UnknownHashAlgorithm")
+
+var ErrGroupAlreadyExists = errors.New("GroupAlreadyExists")
+var ErrGroupNotFound = errors.New("GroupNotFound")
+
+var ErrAPIKeyAlreadyExists = errors.New("APIKeyAlreadyExists")
+var ErrAPIKeyNotFound = errors.New("APIKeyNotFound")
 
 var ErrInvalidRemoteAddr = errors.New("InvalidRemoteAddr")
 
 var ErrKeyExists = errors.New("ErrKeyExists")
 var ErrKeyNotFound = errors.New("ErrKeyNotFound")
+var ErrVersionNotFound = errors.New("VersionNotFound")
+var ErrInvalidTTL = errors.New("InvalidTTL")
+var ErrPreconditionFailed = errors.New("PreconditionFailed")
+var ErrNotModified = errors.New("NotModified")
+var ErrInvalidWait = errors.New("InvalidWait")
 
 var ErrTokenGeneration = errors.New("TokenGeneration")
 var ErrTokenInvalid = errors.New("TokenInvalid")
 var ErrTokenExpired = errors.New("TokenExpired")
+var ErrSessionNotFound = errors.New("SessionNotFound")
 
 var ErrOperationTimeout = errors.New("OperationTimeout")
 
@@ -12,3 +12,6 @@ const CONTEXT_VALUE_AUTH_TOKEN = "auth_token"
 
 // CONTEXT_VALUE_AUTH_USER is the key for the user object stored in the context by the Middleware.
 const CONTEXT_VALUE_AUTH_USER = "auth_user"
+
+// CONTEXT_VALUE_MAXIMUM_WAIT is the key for the longest time a request is allowed to wait for a key.
+const CONTEXT_VALUE_MAXIMUM_WAIT = "maximum_wait"
@@ -26,7 +26,7 @@ func UsesAuthManager[T, R any](
 // Decorator to transform a `EndpointHandlerWithKeyValueCache` into a `EndpointHandler`.
 func UsesAuthManagerAndKeyValueCache[T, R any](
 	authManager *auth.AuthManager,
-	keyValueCache *keyValue.KeyValueCache,
+	keyValueCache keyValue.Store,
 	handler EndpointHandlerWithKeyValueCache[T, R],
 ) EndpointHandler[T, R] {
 	return func(ctx context.Context, input *T) (*R, error) {
@@ -50,6 +50,19 @@ func MustBeCalledFromLoopBack[T, R any](handler EndpointHandler[T, R]) EndpointH
 	}
 }
 
+// Allow the decorated function to wait for keys for up to the specified duration.
+//
+// Use `GetMaximumWaitFromContext` within the handler to find the duration; requests
+// are not allowed to wait at all without this decorator.
+func AllowsWaitingFor[T, R any](
+	duration time.Duration,
+	handler EndpointHandler[T, R],
+) EndpointHandler[T, R] {
+	return func(ctx context.Context, input *T) (*R, error) {
+		return handler(context.WithValue(ctx, CONTEXT_VALUE_MAXIMUM_WAIT, duration), input)
+	}
+}
+
 // Waiter function.
 func timeout(duration time.Duration, channel chan bool) {
 	time.Sleep(duration)
@@ -0,0 +1,39 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Write a single Server-Sent Event to the writer, then flush it to the client.
+func writeServerSentEvent(writer io.Writer, event string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+	return flush(writer)
+}
+
+// Write a comment to the writer, which clients will ignore; this is used to keep the
+// connection alive, and to find out if the client had gone away.
+func writeServerSentComment(writer io.Writer, comment string) error {
+	if _, err := fmt.Fprintf(writer, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	return flush(writer)
+}
+
+// Flush the writer, if it supports flushing.
+func flush(writer io.Writer) error {
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+		return nil
+	}
+	return http.ErrNotSupported
+}
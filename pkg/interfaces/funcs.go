@@ -4,12 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
 
 	"github.com/denwong47/pigeon-hole/pkg/auth"
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 	keyValue "github.com/denwong47/pigeon-hole/pkg/key_value"
+	"github.com/denwong47/pigeon-hole/pkg/tokens"
 	"github.com/denwong47/pigeon-hole/pkg/users"
 )
 
@@ -19,20 +27,25 @@ func AddUser(
 	authManager *auth.AuthManager,
 	input *AddUserRequest,
 ) (*AddUserResponse, error) {
-	privileges, err := users.GetPrivilegesByType(input.Body.Type)
+	role := input.RoleName()
+	privileges, err := authManager.Roles().Privileges(role)
 
 	if err != nil {
-		return &AddUserResponse{}, huma.Error400BadRequest(fmt.Sprintf("Unknown user type `%s`.", input.Body.Type), err)
+		return &AddUserResponse{}, huma.Error400BadRequest(fmt.Sprintf("Unknown role `%s`.", role), err)
 	}
 
-	user := users.NewUser(input.Body.Name, input.Body.Email, privileges).SetPassword(input.Body.Password, &authManager.Options)
+	user, err := users.NewUser(input.Body.Name, input.Body.Email, privileges).SetPassword(input.Body.Password, &authManager.Options)
+	if err != nil {
+		return &AddUserResponse{}, huma.Error400BadRequest(fmt.Sprintf("Failed to set the password of user '%s'.", input.Body.Name), err)
+	}
+	user.Role = role
 
 	if _, err := authManager.AddUser(&user); err != nil {
 		return &AddUserResponse{}, huma.Error400BadRequest(fmt.Sprintf("Failed to add user '%s'.", input.Body.Name), err)
-	} else if tokenData, err := authManager.Tokens.CreateToken(&user); err != nil {
+	} else if tokenData, err := authManager.Tokens.CreateToken(&user, GetRemoteIPFromContext(ctx)); err != nil {
 		return &AddUserResponse{}, huma.Error500InternalServerError("Failed to create token for user.", err)
 	} else {
-		log.Printf("Added user '%s' with UUID `%s` and HashedPass `%s`, user list %s now has %d users.\n", user.Name, user.Uuid, user.HashedPass, authManager.Name, authManager.Length())
+		log.Printf("Added user '%s' with UUID `%s`, user list %s now has %d users.\n", user.Name, user.Uuid, authManager.Name, authManager.Length())
 		return &AddUserResponse{Body: TokenResponseBody{
 			Token:  tokenData.Token,
 			Expiry: tokenData.Expiry,
@@ -40,39 +53,212 @@ func AddUser(
 	}
 }
 
-// Remove a user from the current active user list.
+// Remove a user from the current active user list, optionally giving their keys to another user.
 func RemoveUser(
 	ctx context.Context,
 	authManager *auth.AuthManager,
+	kvc keyValue.Store,
 	input *RemoveUserRequest,
 ) (*RemoveUserResponse, error) {
-	if user, err := authManager.RemoveUser(input.Email); err != nil {
+	var owner *users.User
+	if input.TransferTo != "" {
+		var err error
+		if owner, err = authManager.GetUser(input.TransferTo); err != nil {
+			return &RemoveUserResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s' to transfer keys to.", input.TransferTo), err)
+		} else if owner.Email == strings.ToLower(input.Email) {
+			return &RemoveUserResponse{}, huma.Error400BadRequest("Cannot transfer keys to the user being removed.")
+		}
+	}
+
+	user, err := authManager.GetUser(input.Email)
+	if err != nil {
 		return &RemoveUserResponse{}, huma.Error400BadRequest(fmt.Sprintf("Failed to remove user '%s'.", input.Email), err)
+	}
+
+	// The keys are transferred before the user is removed, so that the user is kept if they
+	// cannot all be transferred, and the removal can be retried.
+	response := &RemoveUserResponse{}
+	if owner != nil {
+		transferred, err := kvc.Transfer(user.Uuid, owner)
+		response.Body.Transferred = transferred
+		if err != nil {
+			return response, huma.Error500InternalServerError(fmt.Sprintf("Failed to transfer all keys of user '%s'; the user was not removed.", user.Email), err)
+		}
+		log.Printf("Transferred %d keys of user '%s' to '%s' (%s).\n", transferred, user.Email, owner.Name, owner.Email)
+	}
+
+	if user, err = authManager.RemoveUser(input.Email); err != nil {
+		return response, huma.Error400BadRequest(fmt.Sprintf("Failed to remove user '%s'.", input.Email), err)
+	}
+	log.Printf("Removed user '%s' with UUID `%s`, user list %s now has %d users.\n", user.Name, user.Uuid, authManager.Name, authManager.Length())
+	revoked := authManager.Tokens.DeleteSessions(user.Uuid)
+	log.Printf("Revoked %d sessions of removed user '%s' (%s).\n", revoked, user.Name, user.Email)
+
+	return response, nil
+}
+
+// List the roles that users can be added with.
+func ListRoles(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ListRolesRequest,
+) (*ListRolesResponse, error) {
+	roles := authManager.Roles()
+
+	body := make([]RoleResponseBody, 0, len(roles))
+	for _, name := range roles.Names() {
+		body = append(body, RoleResponseBody{Name: name, Privileges: roles[name]})
+	}
+
+	return &ListRolesResponse{Body: body}, nil
+}
+
+// Describe a group along with its members.
+func describeGroup(authManager *auth.AuthManager, group *auth.Group) (GroupResponseBody, error) {
+	members, err := authManager.Members(group.Name)
+	return GroupResponseBody{Group: *group, Members: members}, err
+}
+
+// List all the groups and their members.
+func ListGroups(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ListGroupsRequest,
+) (*ListGroupsResponse, error) {
+	groups := authManager.ListGroups()
+
+	body := make([]GroupResponseBody, 0, len(groups))
+	for _, group := range groups {
+		// The group could have been removed since it was listed.
+		if description, err := describeGroup(authManager, group); err == nil {
+			body = append(body, description)
+		}
+	}
+
+	return &ListGroupsResponse{Body: body}, nil
+}
+
+// Add a group that users can be members of.
+func AddGroup(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *AddGroupRequest,
+) (*GroupResponse, error) {
+	group := auth.NewGroup(input.Body.Name, input.Body.Description)
+
+	if _, err := authManager.AddGroup(&group); err != nil {
+		return &GroupResponse{}, huma.Error400BadRequest(fmt.Sprintf("Failed to add group '%s'.", input.Body.Name), err)
+	}
+
+	log.Printf("Added group '%s' with UUID `%s`.\n", group.Name, group.Uuid)
+	return &GroupResponse{Body: GroupResponseBody{Group: group, Members: []string{}}}, nil
+}
+
+// Remove a group, along with all of its memberships.
+//
+// The keys owned by the group remain owned by their individual owners.
+func RemoveGroup(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *GroupRequest,
+) (*GroupResponse, error) {
+	members, _ := authManager.Members(input.Name)
+
+	if group, err := authManager.RemoveGroup(input.Name); err != nil {
+		return &GroupResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find group '%s'.", input.Name), err)
 	} else {
-		log.Printf("Removed user '%s' with UUID `%s`, user list %s now has %d users.\n", user.Name, user.Uuid, authManager.Name, authManager.Length())
-		return &RemoveUserResponse{}, nil
+		log.Printf("Removed group '%s' with UUID `%s` and %d members.\n", group.Name, group.Uuid, len(members))
+		return &GroupResponse{Body: GroupResponseBody{Group: *group, Members: members}}, nil
 	}
 }
 
+// Add a user to a group.
+func AddGroupMember(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *GroupMemberRequest,
+) (*GroupResponse, error) {
+	return changeGroupMembership(authManager, input, authManager.AddMember, "Added user '%s' to group '%s'.\n")
+}
+
+// Remove a user from a group.
+func RemoveGroupMember(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *GroupMemberRequest,
+) (*GroupResponse, error) {
+	return changeGroupMembership(authManager, input, authManager.RemoveMember, "Removed user '%s' from group '%s'.\n")
+}
+
+// Perform a change to the membership of a group, and describe the group afterwards.
+func changeGroupMembership(
+	authManager *auth.AuthManager,
+	input *GroupMemberRequest,
+	change func(name string, email string) (*users.User, error),
+	message string,
+) (*GroupResponse, error) {
+	if _, err := change(input.Name, input.Email); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrGroupNotFound) {
+			return &GroupResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find group '%s'.", input.Name), err)
+		} else {
+			return &GroupResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s'.", input.Email), err)
+		}
+	}
+	log.Printf(message, input.Email, input.Name)
+
+	group, err := authManager.GetGroup(input.Name)
+	if err != nil {
+		return &GroupResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find group '%s'.", input.Name), err)
+	}
+	description, err := describeGroup(authManager, group)
+	if err != nil {
+		return &GroupResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find group '%s'.", input.Name), err)
+	}
+
+	return &GroupResponse{Body: description}, nil
+}
+
 // For an existing user, login with password and return a token.
 func LoginUser(
 	ctx context.Context,
 	authManager *auth.AuthManager,
 	input *LoginUserRequest,
 ) (*LoginUserResponse, error) {
+	// Without the middleware, the IP address is `nil` and only the email is limited.
+	remoteHost, _ := GetRemoteHostFromContext(ctx)
+	if retryAt, err := authManager.Limiter.Check(input.Body.Email, remoteHost.IP); err != nil {
+		log.Printf("Refused login of '%s' from %s until %s, too many failed attempts.\n", input.Body.Email, remoteHost.IP, retryAt.Format(time.RFC3339))
+		return &LoginUserResponse{}, huma.ErrorWithHeaders(
+			huma.Error429TooManyRequests(fmt.Sprintf("Too many failed logins for '%s'; try again after %s.", input.Body.Email, retryAt.Format(time.RFC3339)), err),
+			http.Header{"Retry-After": {strconv.Itoa(int(math.Ceil(time.Until(retryAt).Seconds())))}},
+		)
+	}
+
 	user, err := authManager.GetUser(input.Body.Email)
 
 	if err != nil {
 		log.Printf("Failed to find user '%s'.\n", input.Body.Email)
+		authManager.Limiter.FailUnknown(input.Body.Email, remoteHost.IP)
 		return &LoginUserResponse{}, huma.Error401Unauthorized(fmt.Sprintf("Failed to find user '%s'.", input.Body.Email), err)
 	}
 
 	if !user.CheckPassword(input.Body.Password, &authManager.Options) {
 		log.Printf("User '%s' (%s) failed to authenticate, password mismatch.\n", user.Name, user.Email)
+		authManager.Limiter.Fail(input.Body.Email, remoteHost.IP)
 		return &LoginUserResponse{}, huma.Error401Unauthorized(fmt.Sprintf("Failed to authenticate user '%s'.", input.Body.Email), errorMessages.ErrAuthenticationFailed)
 	}
+	authManager.Limiter.Succeed(input.Body.Email, remoteHost.IP)
+
+	if user.NeedsRehash(&authManager.Options) {
+		if rehashed, err := authManager.RehashPassword(user, input.Body.Password); err != nil {
+			log.Printf("User '%s' (%s) logged in, but their password could not be hashed again: %s\n", user.Name, user.Email, err)
+		} else {
+			log.Printf("User '%s' (%s) had their password hashed again with %s.\n", user.Name, user.Email, authManager.Options.HashAlgorithm)
+			user = rehashed
+		}
+	}
 
-	if tokenData, err := authManager.Tokens.CreateToken(user); err != nil {
+	if tokenData, err := authManager.Tokens.CreateToken(user, GetRemoteIPFromContext(ctx)); err != nil {
 		return &LoginUserResponse{}, huma.Error500InternalServerError("Failed to create token for user.", err)
 	} else {
 		log.Printf("User '%s' (%s) logged in successfully.\n", user.Name, user.Email)
@@ -83,6 +269,66 @@ func LoginUser(
 	}
 }
 
+// ListLockouts lists the emails and IP addresses with failed logins.
+func ListLockouts(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ListLockoutsRequest,
+) (*ListLockoutsResponse, error) {
+	return &ListLockoutsResponse{Body: authManager.Limiter.Lockouts()}, nil
+}
+
+// ClearLockouts clears the failed logins of an email and/or IP address, or all of them if neither is provided.
+func ClearLockouts(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ClearLockoutsRequest,
+) (*ClearLockoutsResponse, error) {
+	response := &ClearLockoutsResponse{}
+
+	if input.IP != "" && net.ParseIP(input.IP) == nil {
+		return response, huma.Error400BadRequest(fmt.Sprintf("Invalid IP address '%s'.", input.IP), errorMessages.ErrInvalidRemoteAddr)
+	}
+
+	if input.Email == "" && input.IP == "" {
+		response.Body.Cleared = authManager.Limiter.Clear("", "")
+	}
+	if input.Email != "" {
+		response.Body.Cleared += authManager.Limiter.Clear(auth.LOCKOUT_BY_EMAIL, input.Email)
+	}
+	if input.IP != "" {
+		response.Body.Cleared += authManager.Limiter.Clear(auth.LOCKOUT_BY_IP, input.IP)
+	}
+
+	log.Printf("Cleared %d lockouts.\n", response.Body.Cleared)
+	return response, nil
+}
+
+// RefreshToken exchanges the token for a new one, revoking the old token.
+func RefreshToken(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *RefreshTokenRequest,
+) (*RefreshTokenResponse, error) {
+	token, ok := GetTokenFromContext(ctx)
+	if !ok {
+		return &RefreshTokenResponse{}, huma.Error401Unauthorized("Cannot refresh without authorisation token.", errorMessages.ErrUnauthorized)
+	}
+
+	tokenData, err := authManager.Tokens.RefreshToken(token, GetRemoteIPFromContext(ctx))
+	if errorMessages.Matches(err, errorMessages.ErrTokenInvalid) || errorMessages.Matches(err, errorMessages.ErrTokenExpired) {
+		return &RefreshTokenResponse{}, huma.Error401Unauthorized("Invalid or expired token provided.", err)
+	} else if err != nil {
+		return &RefreshTokenResponse{}, huma.Error500InternalServerError("Failed to refresh token for user.", err)
+	}
+
+	log.Printf("User '%s' (%s) refreshed their token.\n", tokenData.User.Name, tokenData.User.Email)
+	return &RefreshTokenResponse{Body: TokenResponseBody{
+		Token:  tokenData.Token,
+		Expiry: tokenData.Expiry,
+	}}, nil
+}
+
 // LogoutUser logs out the user by removing the token.
 func LogoutUser(
 	ctx context.Context,
@@ -101,7 +347,7 @@ func LogoutUser(
 			}, nil
 		}
 	} else if token_ok {
-		log.Printf("Cannot logout without token `%s`.\n", token)
+		log.Printf("Cannot logout with invalid token with hash %s.\n", tokens.RedactToken(token))
 		return &LogoutUserResponse{}, huma.Error401Unauthorized("Invalid token provided.", errorMessages.ErrTokenInvalid)
 	} else {
 		return &LogoutUserResponse{}, huma.Error401Unauthorized("Cannot logout without authorisation token.", errorMessages.ErrUnauthorized)
@@ -129,15 +375,46 @@ func GetUserPermission(
 	}
 
 	return &GetUserPermissionResponse{
-		Body: user.Privileges,
+		Body: UserPermissionResponseBody{
+			Owned:  user.Privileges.Owned,
+			All:    user.Privileges.All,
+			Scopes: user.Privileges.Scopes,
+			Rules:  user.Privileges.EffectiveRules(),
+
+			Restriction: user.Restriction,
+		},
 	}, nil
 }
 
+// Extract the `keyValue.KeyValueOptions` from a request, checking that all the recipients exist.
+func getPutKeyOptions(authManager *auth.AuthManager, input *PutKeyRequest) (keyValue.KeyValueOptions, error) {
+	options, err := input.Options()
+	if err != nil {
+		return options, huma.Error400BadRequest("Invalid time-to-live provided.", err)
+	}
+
+	for _, recipient := range options.Recipients {
+		if _, err := authManager.GetUser(recipient); err != nil {
+			return options, huma.Error400BadRequest(fmt.Sprintf("Unknown recipient '%s'.", recipient), err)
+		}
+	}
+
+	if name := input.GroupName(); name != "" {
+		group, err := authManager.GetGroup(name)
+		if err != nil {
+			return options, huma.Error400BadRequest(fmt.Sprintf("Unknown group '%s'.", name), err)
+		}
+		options.Group = &keyValue.KeyValueGroup{Uuid: group.Uuid, Name: group.Name}
+	}
+
+	return options, nil
+}
+
 // GetKey retrieves the value for a given key.
 func GetKey(
 	ctx context.Context,
 	authManager *auth.AuthManager,
-	kvc *keyValue.KeyValueCache,
+	kvc keyValue.Store,
 	input *GetKeyRequest,
 ) (*GetKeyResponse, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -145,17 +422,44 @@ func GetKey(
 		return &GetKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
 	}
 
-	if delivery, err := kvc.GetValue(input.Key, user); err != nil {
-		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+	wait, err := parseWait(input.Wait, GetMaximumWaitFromContext(ctx))
+	if err != nil {
+		return &GetKeyResponse{}, huma.Error400BadRequest("Invalid wait duration provided.", err)
+	}
+
+	conditions := keyValue.KeyValueConditions{IfNoneMatch: input.IfNoneMatch}
+	var delivery keyValue.KeyValueDelivery
+	if input.Version > 0 {
+		delivery, err = kvc.GetVersion(input.Key, input.Version, user)
+	} else if wait > 0 {
+		// Cancelling the request will also stop the wait.
+		waitCtx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		delivery, err = kvc.WaitForValue(waitCtx, input.Key, user, conditions)
+	} else {
+		delivery, err = kvc.GetValueWithConditions(input.Key, user, conditions)
+	}
+
+	if err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrNotModified) {
+			// The object matched one of the entity tags, so it is still there to identify.
+			return &GetKeyResponse{}, huma.ErrorWithHeaders(huma.Status304NotModified(), http.Header{"ETag": {delivery.ETag()}})
+		} else if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
 			return &GetKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrVersionNotFound) {
+			return &GetKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find version %d of key '%s'.", input.Version, input.Key), err)
 		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
 			return &GetKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to access key '%s'.", user.Name, input.Key), err)
 		} else {
 			return &GetKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot retrieve key '%s'.", input.Key), err)
 		}
 	} else {
-		log.Printf("User '%s' (%s) retrieved key '%s'.\n", user.Name, user.Email, input.Key)
-		return &GetKeyResponse{Body: delivery.Value}, nil
+		if delivery.IsReadOnce() {
+			log.Printf("User '%s' (%s) collected read-once key '%s'.\n", user.Name, user.Email, input.Key)
+		} else {
+			log.Printf("User '%s' (%s) retrieved key '%s'.\n", user.Name, user.Email, input.Key)
+		}
+		return &GetKeyResponse{TTL: formatTTL(&delivery), ETag: delivery.ETag(), Body: delivery.Value}, nil
 	}
 }
 
@@ -163,7 +467,7 @@ func GetKey(
 func PutKey(
 	ctx context.Context,
 	authManager *auth.AuthManager,
-	kvc *keyValue.KeyValueCache,
+	kvc keyValue.Store,
 	input *PutKeyRequest,
 ) (*PutKeyResponse, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -171,11 +475,18 @@ func PutKey(
 		return &PutKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
 	}
 
-	if err := kvc.PutValue(input.Key, input.RawBody, user); err != nil {
+	options, err := getPutKeyOptions(authManager, input)
+	if err != nil {
+		return &PutKeyResponse{}, err
+	}
+
+	if err := kvc.PutValueWithOptions(input.Key, input.RawBody, user, user, options); err != nil {
 		if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
 			return &PutKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to add key '%s'.", user.Name, input.Key), err)
 		} else if errorMessages.Matches(err, errorMessages.ErrKeyExists) {
 			return &PutKeyResponse{}, huma.Error409Conflict(fmt.Sprintf("Key '%s' already exists.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &PutKeyResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' does not exist to match.", input.Key), err)
 		} else {
 			return &PutKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot add key '%s'.", input.Key), err)
 		}
@@ -191,7 +502,7 @@ func PutKey(
 func PatchKey(
 	ctx context.Context,
 	authManager *auth.AuthManager,
-	kvc *keyValue.KeyValueCache,
+	kvc keyValue.Store,
 	input *PutKeyRequest,
 ) (*PutKeyResponse, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -199,11 +510,18 @@ func PatchKey(
 		return &PutKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
 	}
 
-	if err := kvc.UpdateValue(input.Key, input.RawBody, user); err != nil {
+	options, err := getPutKeyOptions(authManager, input)
+	if err != nil {
+		return &PutKeyResponse{}, err
+	}
+
+	if err := kvc.UpdateValueWithOptions(input.Key, input.RawBody, user, options); err != nil {
 		if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
 			return &PutKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to update key '%s'.", user.Name, input.Key), err)
 		} else if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
 			return &PutKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Key '%s' does not exists.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &PutKeyResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
 		} else {
 			return &PutKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot update key '%s'.", input.Key), err)
 		}
@@ -219,7 +537,7 @@ func PatchKey(
 func PostKey(
 	ctx context.Context,
 	authManager *auth.AuthManager,
-	kvc *keyValue.KeyValueCache,
+	kvc keyValue.Store,
 	input *PostKeyRequest,
 ) (*PostKeyResponse, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -227,9 +545,16 @@ func PostKey(
 		return &PostKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
 	}
 
-	if err := kvc.PutOrUpdateValue(input.Key, input.RawBody, user); err != nil {
+	options, err := getPutKeyOptions(authManager, (*PutKeyRequest)(input))
+	if err != nil {
+		return &PostKeyResponse{}, err
+	}
+
+	if err := kvc.PutOrUpdateValueWithOptions(input.Key, input.RawBody, user, options); err != nil {
 		if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
 			return &PostKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to add key '%s'.", user.Name, input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &PostKeyResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
 		} else {
 			return &PostKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot add key '%s'.", input.Key), err)
 		}
@@ -245,7 +570,7 @@ func PostKey(
 func DeleteKey(
 	ctx context.Context,
 	authManager *auth.AuthManager,
-	kvc *keyValue.KeyValueCache,
+	kvc keyValue.Store,
 	input *DeleteKeyRequest,
 ) (*DeleteKeyResponse, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -253,11 +578,14 @@ func DeleteKey(
 		return &DeleteKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
 	}
 
-	if delivery, err := kvc.DeleteValue(input.Key, user); err != nil {
+	conditions := keyValue.KeyValueConditions{IfMatch: input.IfMatch}
+	if delivery, err := kvc.DeleteValueWithConditions(input.Key, user, conditions); err != nil {
 		if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
 			return &DeleteKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to delete key '%s'.", user.Name, input.Key), err)
 		} else if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
 			return &DeleteKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &DeleteKeyResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
 		} else {
 			return &DeleteKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot delete key '%s'.", input.Key), err)
 		}
@@ -266,3 +594,497 @@ func DeleteKey(
 		return &DeleteKeyResponse{Body: delivery.Value}, nil
 	}
 }
+
+// ListVersions lists the versions of a key.
+func ListVersions(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *ListVersionsRequest,
+) (*ListVersionsResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &ListVersionsResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	if listings, err := kvc.Versions(input.Key, user); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return &ListVersionsResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return &ListVersionsResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to access key '%s'.", user.Name, input.Key), err)
+		} else {
+			return &ListVersionsResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot list versions of key '%s'.", input.Key), err)
+		}
+	} else {
+		log.Printf("User '%s' (%s) listed %d versions of key '%s'.\n", user.Name, user.Email, len(listings), input.Key)
+		return &ListVersionsResponse{Body: listings}, nil
+	}
+}
+
+// GetKeyMeta retrieves the metadata of a key without its value.
+func GetKeyMeta(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *GetKeyMetaRequest,
+) (*KeyMetaResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &KeyMetaResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	if delivery, err := kvc.Metadata(input.Key, user); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return &KeyMetaResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return &KeyMetaResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to access key '%s'.", user.Name, input.Key), err)
+		} else {
+			return &KeyMetaResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot get metadata of key '%s'.", input.Key), err)
+		}
+	} else {
+		log.Printf("User '%s' (%s) retrieved metadata of key '%s'.\n", user.Name, user.Email, input.Key)
+		return &KeyMetaResponse{Body: keyValue.KeyValueListing{
+			Key:        input.Key,
+			Mode:       delivery.Mode,
+			Size:       delivery.Size,
+			Version:    delivery.Version,
+			Timestamps: delivery.Timestamps,
+			Ownership:  delivery.Ownership,
+		}}, nil
+	}
+}
+
+// RestoreVersion restores a previous version of a key as its current version.
+func RestoreVersion(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *RestoreVersionRequest,
+) (*RestoreVersionResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &RestoreVersionResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	conditions := keyValue.KeyValueConditions{IfMatch: input.IfMatch}
+	if err := kvc.RestoreVersion(input.Key, input.Version, user, conditions); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return &RestoreVersionResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrVersionNotFound) {
+			return &RestoreVersionResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find version %d of key '%s'.", input.Version, input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return &RestoreVersionResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to update key '%s'.", user.Name, input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &RestoreVersionResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
+		} else {
+			return &RestoreVersionResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot restore key '%s'.", input.Key), err)
+		}
+	} else {
+		log.Printf("User '%s' (%s) restored version %d of key '%s'.\n", user.Name, user.Email, input.Version, input.Key)
+		return &RestoreVersionResponse{}, nil
+	}
+}
+
+// SetKeyACL replaces the users a key is shared with.
+func SetKeyACL(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *SetKeyACLRequest,
+) (*SetKeyACLResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &SetKeyACLResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	acl := make([]keyValue.KeyValueGrant, 0, len(input.Body.Grants))
+	for _, grant := range input.Body.Grants {
+		grantee, err := authManager.GetUser(grant.Email)
+		if err != nil {
+			return &SetKeyACLResponse{}, huma.Error400BadRequest(fmt.Sprintf("Unknown user '%s'.", grant.Email), err)
+		}
+		acl = append(acl, keyValue.NewGrant(grantee, users.Permissions{
+			Select: grant.Select,
+			Update: grant.Update,
+			Delete: grant.Delete,
+		}))
+	}
+
+	conditions := keyValue.KeyValueConditions{IfMatch: input.IfMatch}
+	if stored, err := kvc.SetACL(input.Key, acl, user, conditions); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return &SetKeyACLResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return &SetKeyACLResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to share key '%s'.", user.Name, input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &SetKeyACLResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
+		} else {
+			return &SetKeyACLResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot share key '%s'.", input.Key), err)
+		}
+	} else {
+		log.Printf("User '%s' (%s) shared key '%s' with %d users.\n", user.Name, user.Email, input.Key, len(stored))
+		return &SetKeyACLResponse{Body: stored}, nil
+	}
+}
+
+// TransferKey gives a key to another existing user.
+func TransferKey(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *TransferKeyRequest,
+) (*TransferKeyResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &TransferKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	owner, err := authManager.GetUser(input.Body.Email)
+	if err != nil {
+		return &TransferKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s'.", input.Body.Email), err)
+	}
+
+	conditions := keyValue.KeyValueConditions{IfMatch: input.IfMatch}
+	if err := kvc.TransferValue(input.Key, owner, user, conditions); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return &TransferKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find key '%s'.", input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return &TransferKeyResponse{}, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to transfer key '%s'.", user.Name, input.Key), err)
+		} else if errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+			return &TransferKeyResponse{}, huma.Error412PreconditionFailed(fmt.Sprintf("Key '%s' has been modified.", input.Key), err)
+		} else {
+			return &TransferKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("Cannot transfer key '%s'.", input.Key), err)
+		}
+	} else {
+		log.Printf("User '%s' (%s) transferred key '%s' to '%s' (%s).\n", user.Name, user.Email, input.Key, owner.Name, owner.Email)
+		return &TransferKeyResponse{}, nil
+	}
+}
+
+// TransferKeys gives all the keys of a user, who may have been removed, to another existing user.
+func TransferKeys(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *TransferKeysRequest,
+) (*TransferKeysResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &TransferKeysResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	owner, err := authManager.GetUser(input.Body.To)
+	if err != nil {
+		return &TransferKeysResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s'.", input.Body.To), err)
+	}
+
+	// The keys of existing users are matched by their UUID; those of removed users can be
+	// recovered by their UUID, or by their email if no existing user has it.
+	var transferred int
+	if from, lookupErr := authManager.GetUser(input.Body.From); lookupErr == nil {
+		transferred, err = kvc.TransferValues(from.Uuid, owner, user)
+	} else if id, parseErr := uuid.Parse(input.Body.From); parseErr == nil {
+		transferred, err = kvc.TransferValues(id, owner, user)
+	} else {
+		transferred, err = kvc.TransferValuesByEmail(input.Body.From, owner, user)
+	}
+
+	response := &TransferKeysResponse{}
+	response.Body.Transferred = transferred
+	if err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+			return response, huma.Error403Forbidden(fmt.Sprintf("User '%s' is not permitted to transfer the keys of '%s'.", user.Name, input.Body.From), err)
+		} else {
+			return response, huma.Error500InternalServerError(fmt.Sprintf("Failed to transfer all keys of '%s'.", input.Body.From), err)
+		}
+	}
+
+	log.Printf("User '%s' (%s) transferred %d keys of '%s' to '%s' (%s).\n", user.Name, user.Email, transferred, input.Body.From, owner.Name, owner.Email)
+	return response, nil
+}
+
+// ListPigeonHole lists the keys addressed to the user.
+func ListPigeonHole(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *ListPigeonHoleRequest,
+) (*ListPigeonHoleResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &ListPigeonHoleResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	listings := kvc.AddressedTo(user)
+	log.Printf("User '%s' (%s) listed %d keys addressed to them.\n", user.Name, user.Email, len(listings))
+	return &ListPigeonHoleResponse{Body: listings}, nil
+}
+
+// ListKeys lists the keys that the user can retrieve, a page at a time.
+func ListKeys(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *ListKeysRequest,
+) (*ListKeysResponse, error) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return &ListKeysResponse{}, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	page := kvc.List(user, input.Prefix, input.Cursor, input.Limit)
+	log.Printf("User '%s' (%s) listed %d keys with prefix '%s'.\n", user.Name, user.Email, len(page.Keys), input.Prefix)
+	return &ListKeysResponse{Body: page}, nil
+}
+
+// The interval between keep-alive comments on event streams.
+const watchKeepAliveInterval = 30 * time.Second
+
+// WatchKeys streams the changes to the keys that the user can retrieve as Server-Sent Events.
+//
+// The user is looked up again for every event, so that changes to their privileges or group
+// memberships take effect while the stream is open; the stream ends once the token is no
+// longer valid.
+func WatchKeys(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	kvc keyValue.Store,
+	input *WatchKeysRequest,
+) (*huma.StreamResponse, error) {
+	token, token_ok := GetTokenFromContext(ctx)
+	user, user_ok := GetUserFromContext(ctx)
+	if !token_ok || !user_ok {
+		return nil, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	}
+
+	return &huma.StreamResponse{
+		Body: func(ctx huma.Context) {
+			subscription := kvc.SubscribeAs(func() (*users.User, error) {
+				return authManager.CurrentUser(token)
+			}, input.Prefix)
+			defer subscription.Close()
+
+			ctx.SetHeader("Content-Type", "text/event-stream")
+			ctx.SetHeader("Cache-Control", "no-cache")
+			writer := ctx.BodyWriter()
+
+			log.Printf("User '%s' (%s) started watching keys with prefix '%s'.\n", user.Name, user.Email, input.Prefix)
+			if err := writeServerSentComment(writer, "watching"); err != nil {
+				log.Printf("Cannot stream events to user '%s' (%s): %s\n", user.Name, user.Email, err)
+				return
+			}
+
+			keepAlive := time.NewTicker(watchKeepAliveInterval)
+			defer keepAlive.Stop()
+
+			for {
+				var err error
+
+				select {
+				case <-ctx.Context().Done():
+					log.Printf("User '%s' (%s) stopped watching keys.\n", user.Name, user.Email)
+					return
+				case event, ok := <-subscription.Events():
+					if !ok && subscription.Revoked() {
+						log.Printf("Token of user '%s' (%s) is no longer valid, disconnecting.\n", user.Name, user.Email)
+						return
+					} else if !ok {
+						log.Printf("User '%s' (%s) fell behind on events, disconnecting.\n", user.Name, user.Email)
+						return
+					}
+					err = writeServerSentEvent(writer, string(event.Kind), event)
+				case <-keepAlive.C:
+					err = writeServerSentComment(writer, "keep-alive")
+				}
+
+				if err != nil {
+					log.Printf("User '%s' (%s) is no longer watching keys: %s\n", user.Name, user.Email, err)
+					return
+				}
+			}
+		},
+	}, nil
+}
+
+// Find the user of a request to manage their API keys or sessions, which must be authenticated
+// by a token rather than an API key.
+func getLoggedInUser(ctx context.Context) (*users.User, error) {
+	token, token_ok := GetTokenFromContext(ctx)
+	user, user_ok := GetUserFromContext(ctx)
+
+	if !token_ok || !user_ok {
+		return nil, huma.Error401Unauthorized("Authentication failed.", errorMessages.ErrUnauthorized)
+	} else if auth.IsAPIKey(token) {
+		return nil, huma.Error403Forbidden("API keys cannot be used to manage API keys or sessions; login instead.", errorMessages.ErrNotPermitted)
+	}
+	return user, nil
+}
+
+// CreateAPIKey creates a named API key for the user.
+func CreateAPIKey(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *CreateAPIKeyRequest,
+) (*CreateAPIKeyResponse, error) {
+	user, err := getLoggedInUser(ctx)
+	if err != nil {
+		return &CreateAPIKeyResponse{}, err
+	}
+
+	if input.Body.Expiry != nil && !input.Body.Expiry.After(time.Now()) {
+		return &CreateAPIKeyResponse{}, huma.Error400BadRequest("The expiry of the API key must be in the future.", errorMessages.ErrInvalidTTL)
+	}
+	if input.Body.Privileges != nil {
+		if err := input.Body.Privileges.Validate(); err != nil {
+			return &CreateAPIKeyResponse{}, huma.Error400BadRequest(fmt.Sprintf("The privileges of the API key %s.", err), err)
+		}
+	}
+
+	key, apiKey, err := authManager.CreateAPIKey(user, input.Body.Name, input.Body.Expiry, input.Body.Privileges)
+	if errorMessages.Matches(err, errorMessages.ErrAPIKeyAlreadyExists) {
+		return &CreateAPIKeyResponse{}, huma.Error409Conflict(fmt.Sprintf("API key '%s' already exists.", input.Body.Name), err)
+	} else if errorMessages.Matches(err, errorMessages.ErrUserNotFound) {
+		return &CreateAPIKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", err)
+	} else if err != nil {
+		return &CreateAPIKeyResponse{}, huma.Error500InternalServerError("Failed to create API key for user.", err)
+	}
+
+	log.Printf("User '%s' (%s) created API key '%s'.\n", user.Name, user.Email, apiKey.Name)
+	response := &CreateAPIKeyResponse{}
+	response.Body.APIKeyResponseBody = NewAPIKeyResponseBody(apiKey)
+	response.Body.Key = key
+	return response, nil
+}
+
+// ListAPIKeys lists the API keys of the user.
+func ListAPIKeys(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ListAPIKeysRequest,
+) (*ListAPIKeysResponse, error) {
+	user, err := getLoggedInUser(ctx)
+	if err != nil {
+		return &ListAPIKeysResponse{}, err
+	}
+
+	apiKeys, err := authManager.ListAPIKeys(user)
+	if err != nil {
+		return &ListAPIKeysResponse{}, huma.Error401Unauthorized("Authentication failed.", err)
+	}
+
+	body := make([]APIKeyResponseBody, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		body = append(body, NewAPIKeyResponseBody(apiKey))
+	}
+	return &ListAPIKeysResponse{Body: body}, nil
+}
+
+// RevokeAPIKey revokes the API key of the user with the name.
+func RevokeAPIKey(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *RevokeAPIKeyRequest,
+) (*RevokeAPIKeyResponse, error) {
+	user, err := getLoggedInUser(ctx)
+	if err != nil {
+		return &RevokeAPIKeyResponse{}, err
+	}
+
+	if err := authManager.RevokeAPIKey(user, input.Name); errorMessages.Matches(err, errorMessages.ErrAPIKeyNotFound) {
+		return &RevokeAPIKeyResponse{}, huma.Error404NotFound(fmt.Sprintf("API key '%s' not found.", input.Name), err)
+	} else if err != nil {
+		return &RevokeAPIKeyResponse{}, huma.Error401Unauthorized("Authentication failed.", err)
+	}
+
+	log.Printf("User '%s' (%s) revoked API key '%s'.\n", user.Name, user.Email, input.Name)
+	return &RevokeAPIKeyResponse{}, nil
+}
+
+// Describe the sessions of the user, marking the one with the token if any.
+func describeSessions(authManager *auth.AuthManager, user *users.User, token string) []SessionResponseBody {
+	current := ""
+	if token != "" && !auth.IsAPIKey(token) {
+		if tokenData, err := authManager.Tokens.LookupToken(token); err == nil {
+			current = tokenData.Id
+		}
+	}
+
+	sessions := authManager.Tokens.Sessions(user.Uuid)
+	body := make([]SessionResponseBody, 0, len(sessions))
+	for _, session := range sessions {
+		body = append(body, SessionResponseBody{
+			Id:      session.Id,
+			Created: session.Created,
+			Expiry:  session.Expiry,
+			IP:      session.IP,
+			Current: session.Id == current,
+		})
+	}
+	return body
+}
+
+// ListSessions lists the sessions of the user.
+func ListSessions(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *ListSessionsRequest,
+) (*ListSessionsResponse, error) {
+	user, err := getLoggedInUser(ctx)
+	if err != nil {
+		return &ListSessionsResponse{}, err
+	}
+
+	token, _ := GetTokenFromContext(ctx)
+	return &ListSessionsResponse{Body: describeSessions(authManager, user, token)}, nil
+}
+
+// RevokeSession revokes the session of the user with the identifier.
+func RevokeSession(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *RevokeSessionRequest,
+) (*RevokeSessionResponse, error) {
+	user, err := getLoggedInUser(ctx)
+	if err != nil {
+		return &RevokeSessionResponse{}, err
+	}
+
+	if err := authManager.Tokens.DeleteSession(user.Uuid, input.Id); err != nil {
+		return &RevokeSessionResponse{}, huma.Error404NotFound(fmt.Sprintf("Session '%s' not found.", input.Id), err)
+	}
+
+	log.Printf("User '%s' (%s) revoked session '%s'.\n", user.Name, user.Email, input.Id)
+	return &RevokeSessionResponse{}, nil
+}
+
+// ListUserSessions lists the sessions of any user.
+func ListUserSessions(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *UserSessionsRequest,
+) (*ListSessionsResponse, error) {
+	user, err := authManager.GetUser(input.Email)
+	if err != nil {
+		return &ListSessionsResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s'.", input.Email), err)
+	}
+
+	return &ListSessionsResponse{Body: describeSessions(authManager, user, "")}, nil
+}
+
+// RevokeUserSessions revokes all the sessions of any user.
+func RevokeUserSessions(
+	ctx context.Context,
+	authManager *auth.AuthManager,
+	input *UserSessionsRequest,
+) (*RevokeUserSessionsResponse, error) {
+	user, err := authManager.GetUser(input.Email)
+	if err != nil {
+		return &RevokeUserSessionsResponse{}, huma.Error404NotFound(fmt.Sprintf("Failed to find user '%s'.", input.Email), err)
+	}
+
+	response := &RevokeUserSessionsResponse{}
+	response.Body.Revoked = authManager.Tokens.DeleteSessions(user.Uuid)
+
+	log.Printf("Revoked %d sessions of user '%s' (%s).\n", response.Body.Revoked, user.Name, user.Email)
+	return response, nil
+}
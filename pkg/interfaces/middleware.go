@@ -7,10 +7,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/denwong47/pigeon-hole/pkg/auth"
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/tokens"
 	"github.com/denwong47/pigeon-hole/pkg/users"
 )
 
@@ -80,14 +82,14 @@ func PassThroughAuthorizationToken(
 		if err == nil {
 			ctx = huma.WithValue(ctx, CONTEXT_VALUE_AUTH_TOKEN, token)
 
-			// If the token is valid, add the user to the context.
-			if tokenData, err := authManager.Tokens.GetToken(token); err == nil {
-				ctx = huma.WithValue(ctx, CONTEXT_VALUE_AUTH_USER, tokenData.User)
+			// If the token or API key is valid, add the user to the context.
+			if user, err := authManager.Authenticate(token); err == nil {
+				ctx = huma.WithValue(ctx, CONTEXT_VALUE_AUTH_USER, user)
 			} else {
-				log.Printf("Error getting user by token %s: %s\n", token, err)
+				log.Printf("Error getting user by token with hash %s: %s\n", tokens.RedactToken(token), err)
 			}
-		} else if token := ctx.Header("Authorization"); token != "" {
-			log.Printf("Error parsing Authorisation Token %s: %s\n", ctx.Header("Authorization"), err)
+		} else if header := ctx.Header("Authorization"); header != "" {
+			log.Printf("Error parsing Authorisation header with hash %s: %s\n", tokens.RedactToken(header), err)
 		}
 
 		// Call the next middleware in the chain. This eventually calls the
@@ -96,7 +98,23 @@ func PassThroughAuthorizationToken(
 	}
 }
 
-// Extracts the remote address from the context as inserted by the `PassThroughAuthorizationToken` middleware.
+// Extracts the remote address from the context as inserted by the `PassThroughRemoteHost` middleware.
+func GetRemoteHostFromContext(ctx context.Context) (RemoteHost, bool) {
+	if remoteHost, ok := ctx.Value(CONTEXT_VALUE_REMOTE_ADDR).(RemoteHost); ok {
+		return remoteHost, true
+	}
+	return RemoteHost{}, false
+}
+
+// Extracts the IP address of the client from the context as a string, or an empty string if it is not known.
+func GetRemoteIPFromContext(ctx context.Context) string {
+	if remoteHost, ok := GetRemoteHostFromContext(ctx); ok {
+		return remoteHost.IP.String()
+	}
+	return ""
+}
+
+// Extracts the authorization token from the context as inserted by the `PassThroughAuthorizationToken` middleware.
 func GetTokenFromContext(ctx context.Context) (string, bool) {
 	if token, ok := ctx.Value(CONTEXT_VALUE_AUTH_TOKEN).(string); ok {
 		return token, true
@@ -111,3 +129,11 @@ func GetUserFromContext(ctx context.Context) (*users.User, bool) {
 	}
 	return nil, false
 }
+
+// Extracts the longest time the request is allowed to wait, as inserted by the `AllowsWaitingFor` decorator.
+func GetMaximumWaitFromContext(ctx context.Context) time.Duration {
+	if wait, ok := ctx.Value(CONTEXT_VALUE_MAXIMUM_WAIT).(time.Duration); ok {
+		return wait
+	}
+	return 0
+}
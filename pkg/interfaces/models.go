@@ -2,9 +2,11 @@ package interfaces
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/denwong47/pigeon-hole/pkg/auth"
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 	keyValue "github.com/denwong47/pigeon-hole/pkg/key_value"
 	"github.com/denwong47/pigeon-hole/pkg/users"
 )
@@ -15,8 +17,8 @@ type EndpointHandler[T, R any] func(ctx context.Context, input *T) (*R, error)
 // Short Hand for an EndpointHandler that uses an AuthManager.
 type EndpointHandlerWithAuthManager[T, R any] func(ctx context.Context, authManager *auth.AuthManager, input *T) (*R, error)
 
-// Short Hand for an EndpointHandler that uses a KeyValueCache as well as an AuthManager.
-type EndpointHandlerWithKeyValueCache[T, R any] func(ctx context.Context, authManager *auth.AuthManager, keyValueCache *keyValue.KeyValueCache, input *T) (*R, error)
+// Short Hand for an EndpointHandler that uses a key-value Store as well as an AuthManager.
+type EndpointHandlerWithKeyValueCache[T, R any] func(ctx context.Context, authManager *auth.AuthManager, keyValueCache keyValue.Store, input *T) (*R, error)
 
 // KeyRequest is the request object for all endpoints that takes a single key as input.
 type KeyRequest struct {
@@ -34,13 +36,43 @@ type DeliveryResponse struct {
 	Body keyValue.KeyValueDelivery `json:"body" doc:"The object that was delivered."`
 }
 
+// KeyMetaResponse is the response object for the GetKeyMeta endpoint.
+type KeyMetaResponse struct {
+	Body keyValue.KeyValueListing `json:"body" doc:"The metadata of the object, without its value."`
+}
+
 type AddUserRequest struct {
 	Body struct {
 		Name     string `json:"name" doc:"The name of the user to add." required:"true" minLength:"1" maxLength:"1024"`
 		Email    string `json:"email" format:"email" doc:"The email of the user to add." required:"true" minLength:"1" maxLength:"1024"`
 		Password string `json:"password" doc:"The password of the user to add." required:"true" minLength:"8" example:"mySamplePasswordChangeBeforeUse"`
-		Type     string `json:"type" enum:"admin,standard,restricted" doc:"The kind of user to add; this governs the privileges of the user."`
+		Role     string `json:"role,omitempty" doc:"The role of the user to add, which governs the privileges of the user; see the '/roles' endpoint. Defaults to 'standard'." example:"standard"`
+		Type     string `json:"type,omitempty" deprecated:"true" doc:"The role of the user to add; use 'role' instead."`
+	}
+}
+
+// RoleName returns the role requested, falling back to the deprecated type and then the default.
+func (r *AddUserRequest) RoleName() string {
+	if r.Body.Role != "" {
+		return r.Body.Role
+	} else if r.Body.Type != "" {
+		return r.Body.Type
 	}
+	return users.StandardUserType
+}
+
+// ListRolesRequest is the request object for the ListRoles endpoint.
+type ListRolesRequest struct{}
+
+// RoleResponseBody describes a role that users can be added with.
+type RoleResponseBody struct {
+	Name       string           `json:"name" doc:"The name of the role."`
+	Privileges users.Privileges `json:"privileges" doc:"The privileges of users with this role."`
+}
+
+// ListRolesResponse is the response object for the ListRoles endpoint.
+type ListRolesResponse struct {
+	Body []RoleResponseBody `json:"body" doc:"The roles, sorted by name."`
 }
 
 // AddUserResponse is the response object for the AddUser endpoint.
@@ -54,14 +86,55 @@ type TokenResponseBody struct {
 	Expiry time.Time `json:"expiry" doc:"The time the token will expire."`
 }
 
+// GroupResponseBody describes a group and its members.
+type GroupResponseBody struct {
+	auth.Group
+	Members []string `json:"members" doc:"The emails of the members of the group."`
+}
+
+// ListGroupsRequest is the request object for the ListGroups endpoint.
+type ListGroupsRequest struct{}
+
+// ListGroupsResponse is the response object for the ListGroups endpoint.
+type ListGroupsResponse struct {
+	Body []GroupResponseBody `json:"body" doc:"The groups, sorted by name."`
+}
+
+// AddGroupRequest is the request object for the AddGroup endpoint.
+type AddGroupRequest struct {
+	Body struct {
+		Name        string `json:"name" doc:"The name of the group to add." required:"true" minLength:"1" maxLength:"64" pattern:"^[A-Za-z0-9][A-Za-z0-9_.-]*$" example:"backend"`
+		Description string `json:"description,omitempty" doc:"What the group is for." maxLength:"1024"`
+	}
+}
+
+// GroupRequest is the request object for the endpoints operating on a group.
+type GroupRequest struct {
+	Name string `path:"name" doc:"The name of the group." minLength:"1" maxLength:"64" example:"backend"`
+}
+
+// GroupMemberRequest is the request object for the endpoints operating on a member of a group.
+type GroupMemberRequest struct {
+	Name  string `path:"name" doc:"The name of the group." minLength:"1" maxLength:"64" example:"backend"`
+	Email string `path:"email" format:"email" doc:"The email of the user." minLength:"1" maxLength:"1024" example:"user@example.com"`
+}
+
+// GroupResponse is the response object for the endpoints operating on a group.
+type GroupResponse struct {
+	Body GroupResponseBody `json:"body" doc:"The group after the operation."`
+}
+
 // RemoveUserRequest is the request object for the RemoveUser endpoint.
 type RemoveUserRequest struct {
-	Email string `path:"email" format:"email" doc:"The email of the user to remove." required:"true" minLength:"1" maxLength:"1024" example:"user@example.com"`
+	Email      string `path:"email" format:"email" doc:"The email of the user to remove." required:"true" minLength:"1" maxLength:"1024" example:"user@example.com"`
+	TransferTo string `query:"transferTo" format:"email" maxLength:"1024" example:"colleague@example.com" doc:"Give all the keys owned by the removed user to this existing user before removing them; otherwise, their keys remain owned by the removed user, and can only be accessed through their group, their access control list, or privileges over all keys, until they are transferred with '/keys/owner'."`
 }
 
 // RemoveUserResponse is the response object for the RemoveUser endpoint.
 type RemoveUserResponse struct {
-	Body struct{} `json:"body" doc:"Content of the response."`
+	Body struct {
+		Transferred int `json:"transferred" doc:"The number of keys given to the user in 'transferTo'."`
+	} `json:"body" doc:"Content of the response."`
 }
 
 // LoginUserRequest is the request object for the LoginUser endpoint.
@@ -78,11 +151,42 @@ type LoginUserResponse struct {
 	Body          TokenResponseBody `json:"body" doc:"Content of the response."`
 }
 
+// ListLockoutsRequest is the request object for the ListLockouts endpoint.
+type ListLockoutsRequest struct{}
+
+// ListLockoutsResponse is the response object for the ListLockouts endpoint.
+type ListLockoutsResponse struct {
+	Body []auth.Lockout `json:"body" doc:"The emails and IP addresses with failed logins, most recent failure first."`
+}
+
+// ClearLockoutsRequest is the request object for the ClearLockouts endpoint.
+type ClearLockoutsRequest struct {
+	Email string `query:"email" format:"email" maxLength:"1024" example:"user@example.com" doc:"Clear the failed logins of this email."`
+	IP    string `query:"ip" maxLength:"64" example:"192.0.2.1" doc:"Clear the failed logins from this IP address."`
+}
+
+// ClearLockoutsResponse is the response object for the ClearLockouts endpoint.
+type ClearLockoutsResponse struct {
+	Body struct {
+		Cleared int `json:"cleared" doc:"The number of emails and IP addresses cleared."`
+	} `json:"body" doc:"Content of the response."`
+}
+
 // LogoutUserRequest is the request object for the LogoutUser endpoint.
 type LogoutUserRequest struct {
 	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
 }
 
+// RefreshTokenRequest is the request object for the RefreshToken endpoint.
+type RefreshTokenRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token to refresh, which must not have expired. Obtain using the '/login' endpoint." example:"Bearer token"`
+}
+
+// RefreshTokenResponse is the response object for the RefreshToken endpoint.
+type RefreshTokenResponse struct {
+	Body TokenResponseBody `json:"body" doc:"Content of the response."`
+}
+
 // LogoutUserResponse is the response object for the LogoutUser endpoint.
 type LogoutUserResponse struct {
 	Body struct{} `json:"body" doc:"Content of the response."`
@@ -93,26 +197,225 @@ type GetUserPermissionRequest LogoutUserRequest
 
 // GetUserPermissionResponse is the response object for the GetUserPermission endpoint.
 type GetUserPermissionResponse struct {
-	Body users.Privileges `json:"privileges" doc:"The privileges of the user."`
+	Body UserPermissionResponseBody `json:"privileges" doc:"The privileges of the user."`
+}
+
+// UserPermissionResponseBody describes the privileges of a user.
+type UserPermissionResponseBody struct {
+	Owned  users.Permissions  `json:"owned" doc:"The default permissions the user has on objects they own."`
+	All    users.Permissions  `json:"all" doc:"The default permissions the user has on all objects."`
+	Scopes []string           `json:"scopes,omitempty" doc:"The key prefixes the privileges are limited to; the user has no permissions on any other key. All keys if empty."`
+	Rules  []users.PrefixRule `json:"rules" doc:"The permissions in effect within the scopes, in the order they are matched: the rule with the longest prefix of a key applies, ending with the default permissions under an empty prefix."`
+
+	Restriction *users.Privileges `json:"restriction,omitempty" doc:"The privileges of the API key the request was authenticated with, which further restrict those above."`
+}
+
+// CreateAPIKeyRequest is the request object for the CreateAPIKey endpoint.
+type CreateAPIKeyRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint; API keys cannot be used to manage API keys." example:"Bearer token"`
+	Body          struct {
+		Name       string            `json:"name" doc:"The name of the key, unique for the user." required:"true" minLength:"1" maxLength:"64" pattern:"^[A-Za-z0-9][A-Za-z0-9_.-]*$" example:"ci-robot"`
+		Expiry     *time.Time        `json:"expiry,omitempty" required:"false" doc:"The time the key expires; it never expires if not provided."`
+		Privileges *users.Privileges `json:"privileges,omitempty" required:"false" doc:"Restrict the key to these privileges, within those of the user; the key has all the privileges of the user if not provided."`
+	}
+}
+
+// APIKeyResponseBody describes an API key, without the key itself.
+type APIKeyResponseBody struct {
+	Name       string            `json:"name" doc:"The name of the key."`
+	Created    time.Time         `json:"created" doc:"The time the key was created."`
+	Expiry     *time.Time        `json:"expiry,omitempty" doc:"The time the key expires; it never expires if not set."`
+	Privileges *users.Privileges `json:"privileges,omitempty" doc:"The privileges the key is restricted to, within those of the user; those of the user if not set."`
+}
+
+// Describe an API key, without the key itself.
+func NewAPIKeyResponseBody(apiKey users.APIKey) APIKeyResponseBody {
+	return APIKeyResponseBody{
+		Name:       apiKey.Name,
+		Created:    apiKey.Created,
+		Expiry:     apiKey.Expiry,
+		Privileges: apiKey.Privileges,
+	}
+}
+
+// CreateAPIKeyResponse is the response object for the CreateAPIKey endpoint.
+type CreateAPIKeyResponse struct {
+	Body struct {
+		APIKeyResponseBody
+		Key string `json:"key" doc:"The API key; use it in the Authorization header as 'Bearer <key>'. This cannot be retrieved again."`
+	} `json:"body" doc:"Content of the response."`
+}
+
+// ListAPIKeysRequest is the request object for the ListAPIKeys endpoint.
+type ListAPIKeysRequest LogoutUserRequest
+
+// ListAPIKeysResponse is the response object for the ListAPIKeys endpoint.
+type ListAPIKeysResponse struct {
+	Body []APIKeyResponseBody `json:"body" doc:"The API keys of the user."`
+}
+
+// SessionResponseBody describes a session of a user, without its token.
+type SessionResponseBody struct {
+	Id      string    `json:"id" doc:"The identifier of the session."`
+	Created time.Time `json:"created" doc:"The time the session was created, by logging in or refreshing a token."`
+	Expiry  time.Time `json:"expiry" doc:"The time the session expires."`
+	IP      string    `json:"ip,omitempty" doc:"The IP address the session was created from."`
+	Current bool      `json:"current" doc:"Whether this is the session the request was made with."`
+}
+
+// ListSessionsRequest is the request object for the ListSessions endpoint.
+type ListSessionsRequest LogoutUserRequest
+
+// ListSessionsResponse is the response object for the ListSessions and ListUserSessions endpoints.
+type ListSessionsResponse struct {
+	Body []SessionResponseBody `json:"body" doc:"The unexpired sessions of the user, oldest first."`
+}
+
+// RevokeSessionRequest is the request object for the RevokeSession endpoint.
+type RevokeSessionRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint; API keys cannot be used to manage sessions." example:"Bearer token"`
+	Id            string `path:"id" maxLength:"64" example:"2b1e5b4e-3c6b-4a8e-9d4e-8f1d3c2a1b0c" doc:"The identifier of the session to revoke. Obtain this from the '/user/sessions' endpoint."`
+}
+
+// RevokeSessionResponse is the response object for the RevokeSession endpoint.
+type RevokeSessionResponse struct {
+	Body struct{} `json:"body" doc:"Content of the response."`
+}
+
+// UserSessionsRequest is the request object for the ListUserSessions and RevokeUserSessions endpoints.
+type UserSessionsRequest struct {
+	Email string `path:"email" format:"email" doc:"The email of the user." required:"true" minLength:"1" maxLength:"1024" example:"user@example.com"`
+}
+
+// RevokeUserSessionsResponse is the response object for the RevokeUserSessions endpoint.
+type RevokeUserSessionsResponse struct {
+	Body struct {
+		Revoked int `json:"revoked" doc:"The number of sessions revoked."`
+	} `json:"body" doc:"Content of the response."`
+}
+
+// RevokeAPIKeyRequest is the request object for the RevokeAPIKey endpoint.
+type RevokeAPIKeyRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint; API keys cannot be used to manage API keys." example:"Bearer token"`
+	Name          string `path:"name" maxLength:"64" example:"ci-robot" doc:"The name of the key to revoke."`
+}
+
+// RevokeAPIKeyResponse is the response object for the RevokeAPIKey endpoint.
+type RevokeAPIKeyResponse struct {
+	Body struct{} `json:"body" doc:"Content of the response."`
 }
 
 // GetKeyRequest is the request object for the GetKey endpoint.
 type GetKeyRequest struct {
-	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
-	Key           string `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	Authorization string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	IfNoneMatch   []string `header:"If-None-Match" doc:"Only retrieve the object if its ETag matches none of these; otherwise, respond with 304 Not Modified."`
+	Wait          string   `query:"wait" example:"30s" doc:"If the key does not exist, or matches the 'If-None-Match' header, wait up to this long for it to be created or changed; either in seconds or as a duration such as '30s'. This is limited by the server timeout."`
+	Version       uint64   `query:"version" doc:"Fetch this version of the object instead of the current one; use the '/key/{key}/versions' endpoint to list them."`
 }
 
 // GetKeyResponse is the response object for the GetKey endpoint.
 type GetKeyResponse struct {
 	// Body keyValue.KeyValueDelivery `json:"body" doc:"Content of the response."`
+	TTL  string `header:"X-TTL" doc:"The remaining time-to-live of the object; absent if the object does not expire."`
+	ETag string `header:"ETag" doc:"Identifies the object and its version; use this in the 'If-Match' header of subsequent updates."`
 	Body []byte `doc:"The byte content of the stored object."`
 }
 
+// Format the remaining time-to-live of a delivery for the `X-TTL` header.
+func formatTTL(delivery *keyValue.KeyValueDelivery) string {
+	if ttl, ok := delivery.TTL(); ok {
+		return ttl.Round(time.Second).String()
+	}
+	return ""
+}
+
 // PutKeyRequest is the request object for the PutKey endpoint.
 type PutKeyRequest struct {
-	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
-	Key           string `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
-	RawBody       []byte
+	Authorization    string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key              string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	TTL              string   `query:"ttl" example:"5m" doc:"The time-to-live of the object, either in seconds or as a duration such as '90s' or '2h'. Takes precedence over the 'X-TTL' header."`
+	TTLHeader        string   `header:"X-TTL" example:"5m" doc:"The time-to-live of the object, either in seconds or as a duration such as '90s' or '2h'."`
+	Mode             string   `query:"mode" enum:"persistent,once" doc:"The delivery mode of the object; 'once' objects are removed upon first retrieval. Takes precedence over the 'X-Delivery-Mode' header."`
+	ModeHeader       string   `header:"X-Delivery-Mode" enum:"persistent,once" doc:"The delivery mode of the object; 'once' objects are removed upon first retrieval."`
+	Recipients       []string `query:"recipients" doc:"Comma separated emails of the users to address the object to. Takes precedence over the 'X-Recipients' header."`
+	RecipientsHeader []string `header:"X-Recipients" doc:"Comma separated emails of the users to address the object to."`
+	IfMatch          []string `header:"If-Match" doc:"Only write the object if the ETag of the existing object matches one of these; otherwise, respond with 412 Precondition Failed."`
+	Group            string   `query:"group" maxLength:"64" doc:"The name of a group the user is a member of, to own the new object along with the user. Takes precedence over the 'X-Group' header."`
+	GroupHeader      string   `header:"X-Group" maxLength:"64" doc:"The name of a group the user is a member of, to own the new object along with the user."`
+	RawBody          []byte
+}
+
+// Options extracts the `keyValue.KeyValueOptions` from the request.
+func (r *PutKeyRequest) Options() (keyValue.KeyValueOptions, error) {
+	ttl, err := parseTTL(r.TTL, r.TTLHeader)
+	if err != nil {
+		return keyValue.KeyValueOptions{}, err
+	}
+
+	mode := r.Mode
+	if mode == "" {
+		mode = r.ModeHeader
+	}
+
+	recipients := r.Recipients
+	if len(recipients) == 0 {
+		recipients = r.RecipientsHeader
+	}
+
+	return keyValue.KeyValueOptions{
+		TTL:        ttl,
+		Mode:       keyValue.DeliveryMode(mode),
+		Recipients: recipients,
+		Conditions: keyValue.KeyValueConditions{IfMatch: r.IfMatch},
+	}, nil
+}
+
+// GroupName extracts the name of the group that should own the object, if any.
+func (r *PutKeyRequest) GroupName() string {
+	if r.Group != "" {
+		return r.Group
+	}
+	return r.GroupHeader
+}
+
+// Parse the first non-empty time-to-live value, either as whole seconds or as a duration.
+func parseTTL(candidates ...string) (time.Duration, error) {
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+
+		if ttl, ok := parseDuration(candidate); ok {
+			return ttl, nil
+		}
+		return 0, errorMessages.ErrInvalidTTL
+	}
+
+	return 0, nil
+}
+
+// Parse a non-negative duration, either as whole seconds or as a duration such as '90s'.
+func parseDuration(candidate string) (time.Duration, bool) {
+	duration, err := time.ParseDuration(candidate)
+	if seconds, atoiErr := strconv.Atoi(candidate); atoiErr == nil {
+		duration, err = time.Duration(seconds)*time.Second, nil
+	}
+
+	return duration, err == nil && duration >= 0
+}
+
+// Parse the time to wait for a key, limited to `maximum`.
+func parseWait(candidate string, maximum time.Duration) (time.Duration, error) {
+	if candidate == "" {
+		return 0, nil
+	}
+
+	wait, ok := parseDuration(candidate)
+	if !ok {
+		return 0, errorMessages.ErrInvalidWait
+	}
+	return min(wait, maximum), nil
 }
 
 // GetKeyResponse is the response object for the GetKey endpoint.
@@ -127,7 +430,122 @@ type PostKeyRequest PutKeyRequest
 type PostKeyResponse PutKeyResponse
 
 // DeleteKeyRequest is the request object for the DeleteKey endpoint.
-type DeleteKeyRequest GetKeyRequest
+type DeleteKeyRequest struct {
+	Authorization string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	IfMatch       []string `header:"If-Match" doc:"Only delete the object if its ETag matches one of these; otherwise, respond with 412 Precondition Failed."`
+}
 
 // DeleteKeyResponse is the response object for the DeleteKey endpoint.
 type DeleteKeyResponse GetKeyResponse
+
+// ListVersionsRequest is the request object for the ListVersions endpoint.
+type ListVersionsRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+}
+
+// GetKeyMetaRequest is the request object for the GetKeyMeta endpoint.
+type GetKeyMetaRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+}
+
+// ListVersionsResponse is the response object for the ListVersions endpoint.
+type ListVersionsResponse struct {
+	Body []keyValue.KeyValueListing `json:"body" doc:"The versions of the object, newest first, without their values."`
+}
+
+// RestoreVersionRequest is the request object for the RestoreVersion endpoint.
+type RestoreVersionRequest struct {
+	Authorization string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	Version       uint64   `path:"version" doc:"The version of the object to restore."`
+	IfMatch       []string `header:"If-Match" doc:"Only restore the version if the ETag of the current object matches one of these; otherwise, respond with 412 Precondition Failed."`
+}
+
+// RestoreVersionResponse is the response object for the RestoreVersion endpoint.
+type RestoreVersionResponse struct {
+	Body struct{} `json:"body"`
+}
+
+// SetKeyACLRequest is the request object for the SetKeyACL endpoint.
+type SetKeyACLRequest struct {
+	Authorization string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	IfMatch       []string `header:"If-Match" doc:"Only change the access control list if the ETag of the object matches one of these; otherwise, respond with 412 Precondition Failed."`
+	Body          struct {
+		Grants []KeyACLGrant `json:"grants" doc:"The users to share the key with, replacing any existing grants; use an empty list to stop sharing the key." required:"true" maxItems:"1024"`
+	}
+}
+
+// KeyACLGrant is a grant of access to a key for a user, as requested.
+type KeyACLGrant struct {
+	Email  string `json:"email" format:"email" doc:"The email of the existing user to share the key with." required:"true" minLength:"1" maxLength:"1024" example:"colleague@example.com"`
+	Select bool   `json:"read,omitempty" doc:"Whether the user can read the key."`
+	Update bool   `json:"update,omitempty" doc:"Whether the user can update the key."`
+	Delete bool   `json:"delete,omitempty" doc:"Whether the user can delete the key."`
+}
+
+// SetKeyACLResponse is the response object for the SetKeyACL endpoint.
+type SetKeyACLResponse struct {
+	Body []keyValue.KeyValueGrant `json:"body" doc:"The access control list of the key as stored; grants for the owner, or without any permissions, are discarded."`
+}
+
+// TransferKeyRequest is the request object for the TransferKey endpoint.
+type TransferKeyRequest struct {
+	Authorization string   `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Key           string   `path:"key" maxLength:"1024" example:"myObjectKey" doc:"The object key of the desired delivery. Obtain this from the sender."`
+	IfMatch       []string `header:"If-Match" doc:"Only transfer the object if its ETag matches one of these; otherwise, respond with 412 Precondition Failed."`
+	Body          struct {
+		Email string `json:"email" format:"email" doc:"The email of the existing user to give the key to." required:"true" minLength:"1" maxLength:"1024" example:"colleague@example.com"`
+	}
+}
+
+// TransferKeyResponse is the response object for the TransferKey endpoint.
+type TransferKeyResponse struct {
+	Body struct{} `json:"body"`
+}
+
+// TransferKeysRequest is the request object for the TransferKeys endpoint.
+type TransferKeysRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Body          struct {
+		From string `json:"from" doc:"The email of the user who currently owns the keys; for a user who has been removed, their email or their UUID." required:"true" minLength:"1" maxLength:"1024" example:"leaver@example.com"`
+		To   string `json:"to" format:"email" doc:"The email of the existing user to give the keys to." required:"true" minLength:"1" maxLength:"1024" example:"colleague@example.com"`
+	}
+}
+
+// TransferKeysResponse is the response object for the TransferKeys endpoint.
+type TransferKeysResponse struct {
+	Body struct {
+		Transferred int `json:"transferred" doc:"The number of keys transferred."`
+	} `json:"body"`
+}
+
+// ListPigeonHoleRequest is the request object for the ListPigeonHole endpoint.
+type ListPigeonHoleRequest LogoutUserRequest
+
+// ListPigeonHoleResponse is the response object for the ListPigeonHole endpoint.
+type ListPigeonHoleResponse struct {
+	Body []keyValue.KeyValueListing `json:"body" doc:"The keys addressed to the user, without their values."`
+}
+
+// ListKeysRequest is the request object for the ListKeys endpoint.
+type ListKeysRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Prefix        string `query:"prefix" maxLength:"1024" doc:"Only list the keys starting with this prefix."`
+	Cursor        string `query:"cursor" maxLength:"1024" doc:"Only list the keys after this one; use the 'nextCursor' of the previous page."`
+	Limit         int    `query:"limit" minimum:"1" maximum:"1000" default:"100" doc:"The maximum number of keys to list."`
+}
+
+// WatchKeysRequest is the request object for the WatchKeys endpoint.
+type WatchKeysRequest struct {
+	Authorization string `header:"Authorization" doc:"The Auth token of the requested user. Obtain using the '/login' endpoint." example:"Bearer token"`
+	Prefix        string `query:"prefix" maxLength:"1024" doc:"Only watch the keys starting with this prefix."`
+}
+
+// ListKeysResponse is the response object for the ListKeys endpoint.
+type ListKeysResponse struct {
+	Body keyValue.KeyValuePage `json:"body" doc:"A page of the keys that the user can retrieve."`
+}
@@ -0,0 +1,332 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/fxamacker/cbor/v2"
+	"golang.org/x/net/websocket"
+
+	"github.com/denwong47/pigeon-hole/pkg/auth"
+	keyValue "github.com/denwong47/pigeon-hole/pkg/key_value"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// SocketOperation is the operation requested by a WebSocket message.
+type SocketOperation string
+
+const (
+	// Fetch a key, as the GetKey endpoint.
+	SocketGet SocketOperation = "get"
+	// Add a new key, as the PutKey endpoint.
+	SocketPut SocketOperation = "put"
+	// Update an existing key, as the PatchKey endpoint.
+	SocketPatch SocketOperation = "patch"
+	// Add or update a key, as the PostKey endpoint.
+	SocketPost SocketOperation = "post"
+	// Delete a key, as the DeleteKey endpoint.
+	SocketDelete SocketOperation = "delete"
+	// Receive the changes to the keys with a prefix, as the WatchKeys endpoint.
+	SocketSubscribe SocketOperation = "subscribe"
+	// Stop receiving the changes of the earlier subscription with the same ID.
+	SocketUnsubscribe SocketOperation = "unsubscribe"
+)
+
+// SocketRequest is a message sent by the client over the WebSocket.
+//
+// Text frames are decoded as JSON, and binary frames as CBOR; the responses are encoded in
+// the same format as the request.
+type SocketRequest struct {
+	ID          string          `json:"id" doc:"Chosen by the client to match the responses to this request."`
+	Op          SocketOperation `json:"op" doc:"The operation to perform."`
+	Key         string          `json:"key,omitempty" doc:"The key to operate on."`
+	Prefix      string          `json:"prefix,omitempty" doc:"The prefix of the keys to subscribe to."`
+	Value       []byte          `json:"value,omitempty" doc:"The value to write; base64 encoded in JSON."`
+	TTL         string          `json:"ttl,omitempty" doc:"The time-to-live of the value to write."`
+	Mode        string          `json:"mode,omitempty" doc:"The delivery mode of the value to write."`
+	Recipients  []string        `json:"recipients,omitempty" doc:"The emails of the users to address the value to."`
+	IfMatch     []string        `json:"ifMatch,omitempty" doc:"Only write or delete the key if its ETag matches one of these."`
+	IfNoneMatch []string        `json:"ifNoneMatch,omitempty" doc:"Only fetch the key if its ETag matches none of these."`
+	Group       string          `json:"group,omitempty" doc:"The name of the group to own the key written, along with the user."`
+}
+
+// SocketResponse is a message sent by the server over the WebSocket, either in response to a
+// request, or as an event of a subscription.
+type SocketResponse struct {
+	ID     string                  `json:"id" doc:"The ID of the request or subscription this message belongs to."`
+	Status int                     `json:"status" doc:"The HTTP status code equivalent to the outcome of the request."`
+	Error  string                  `json:"error,omitempty" doc:"The reason the request failed."`
+	Value  []byte                  `json:"value,omitempty" doc:"The value fetched or deleted; base64 encoded in JSON."`
+	Size   int                     `json:"size,omitempty" doc:"The number of bytes written."`
+	TTL    string                  `json:"ttl,omitempty" doc:"The remaining time-to-live of the key fetched."`
+	ETag   string                  `json:"etag,omitempty" doc:"The ETag of the key fetched."`
+	Event  *keyValue.KeyValueEvent `json:"event,omitempty" doc:"The change to a key that was subscribed to."`
+}
+
+// CBOR encoding that keeps the precision of timestamps, to match the JSON encoding.
+var socketCBOR, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+// socketCodec encodes messages as JSON in text frames, or CBOR in binary frames.
+type socketCodec struct {
+	payloadType byte
+}
+
+// Encode a message in the format of the codec.
+func (c socketCodec) marshal(v interface{}) ([]byte, byte, error) {
+	if c.payloadType == websocket.BinaryFrame {
+		data, err := socketCBOR.Marshal(v)
+		return data, websocket.BinaryFrame, err
+	}
+
+	data, err := json.Marshal(v)
+	return data, websocket.TextFrame, err
+}
+
+// Decode a message in the format of its frame.
+func unmarshalSocketMessage(data []byte, payloadType byte, v interface{}) error {
+	if payloadType == websocket.BinaryFrame {
+		return cbor.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Receive a single frame, returning its payload and type.
+func receiveSocketFrame(conn *websocket.Conn) ([]byte, byte, error) {
+	var data []byte
+	var payloadType byte
+
+	receiver := websocket.Codec{Unmarshal: func(frame []byte, frameType byte, v interface{}) error {
+		data, payloadType = frame, frameType
+		return nil
+	}}
+	err := receiver.Receive(conn, nil)
+
+	return data, payloadType, err
+}
+
+// socketSession is a single authenticated WebSocket connection.
+//
+// The token is authenticated again for every request, and `user` replaced with the user it
+// currently belongs to, so that changes to their privileges take effect on the open connection.
+type socketSession struct {
+	conn          *websocket.Conn
+	authManager   *auth.AuthManager
+	kvc           keyValue.Store
+	token         string
+	user          *users.User
+	subscriptions map[string]*keyValue.Subscription
+	sendLock      sync.Mutex
+	wg            sync.WaitGroup
+}
+
+// Send a message to the client; this is safe to call from multiple goroutines.
+func (s *socketSession) send(codec socketCodec, response SocketResponse) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	return websocket.Codec{Marshal: codec.marshal}.Send(s.conn, response)
+}
+
+// Convert the outcome of a REST handler into a response.
+func socketResponseFromError(id string, err error) SocketResponse {
+	var statusErr huma.StatusError
+	if errors.As(err, &statusErr) {
+		return SocketResponse{ID: id, Status: statusErr.GetStatus(), Error: statusErr.Error()}
+	}
+	return SocketResponse{ID: id, Status: http.StatusInternalServerError, Error: err.Error()}
+}
+
+// Perform a request by calling the equivalent REST handler as the user, so that all the same
+// checks apply.
+func (s *socketSession) handle(ctx context.Context, codec socketCodec, request SocketRequest) SocketResponse {
+	ctx = context.WithValue(ctx, CONTEXT_VALUE_AUTH_TOKEN, s.token)
+	ctx = context.WithValue(ctx, CONTEXT_VALUE_AUTH_USER, s.user)
+
+	putRequest := &PutKeyRequest{
+		Key:        request.Key,
+		TTL:        request.TTL,
+		Mode:       request.Mode,
+		Recipients: request.Recipients,
+		IfMatch:    request.IfMatch,
+		Group:      request.Group,
+		RawBody:    request.Value,
+	}
+
+	var response SocketResponse
+	var err error
+
+	switch request.Op {
+	case SocketGet:
+		var output *GetKeyResponse
+		if output, err = GetKey(ctx, s.authManager, s.kvc, &GetKeyRequest{Key: request.Key, IfNoneMatch: request.IfNoneMatch}); err == nil {
+			response = SocketResponse{Value: output.Body, TTL: output.TTL, ETag: output.ETag}
+		}
+	case SocketPut:
+		var output *PutKeyResponse
+		if output, err = PutKey(ctx, s.authManager, s.kvc, putRequest); err == nil {
+			response = SocketResponse{Size: output.Body}
+		}
+	case SocketPatch:
+		var output *PutKeyResponse
+		if output, err = PatchKey(ctx, s.authManager, s.kvc, putRequest); err == nil {
+			response = SocketResponse{Size: output.Body}
+		}
+	case SocketPost:
+		var output *PostKeyResponse
+		if output, err = PostKey(ctx, s.authManager, s.kvc, (*PostKeyRequest)(putRequest)); err == nil {
+			response = SocketResponse{Size: output.Body}
+		}
+	case SocketDelete:
+		var output *DeleteKeyResponse
+		if output, err = DeleteKey(ctx, s.authManager, s.kvc, &DeleteKeyRequest{Key: request.Key, IfMatch: request.IfMatch}); err == nil {
+			response = SocketResponse{Value: output.Body}
+		}
+	case SocketSubscribe:
+		err = s.subscribe(codec, request)
+	case SocketUnsubscribe:
+		err = s.unsubscribe(request.ID)
+	default:
+		err = huma.Error400BadRequest(fmt.Sprintf("Unknown operation '%s'.", request.Op))
+	}
+
+	if err != nil {
+		return socketResponseFromError(request.ID, err)
+	}
+
+	response.ID = request.ID
+	response.Status = http.StatusOK
+	return response
+}
+
+// Start forwarding the events of the keys with the prefix to the client, tagged with the ID of
+// the request.
+func (s *socketSession) subscribe(codec socketCodec, request SocketRequest) error {
+	if request.ID == "" {
+		return huma.Error400BadRequest("Subscriptions require an ID.")
+	} else if _, ok := s.subscriptions[request.ID]; ok {
+		return huma.Error409Conflict(fmt.Sprintf("Subscription '%s' already exists.", request.ID))
+	}
+
+	// The user is looked up again for every event, as their privileges could change, or their
+	// token be revoked, while no requests are made.
+	subscription := s.kvc.SubscribeAs(func() (*users.User, error) {
+		return s.authManager.CurrentUser(s.token)
+	}, request.Prefix)
+	s.subscriptions[request.ID] = subscription
+	log.Printf("User '%s' (%s) subscribed to keys with prefix '%s' over WebSocket.\n", s.user.Name, s.user.Email, request.Prefix)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for event := range subscription.Events() {
+			if err := s.send(codec, SocketResponse{ID: request.ID, Status: http.StatusOK, Event: &event}); err != nil {
+				subscription.Close()
+			}
+		}
+
+		// Tell the client if they fell behind, so that they can catch up.
+		if subscription.Overflowed() {
+			s.send(codec, SocketResponse{ID: request.ID, Status: http.StatusGone, Error: "The subscription fell behind on events and was closed."})
+		} else if subscription.Revoked() {
+			s.send(codec, SocketResponse{ID: request.ID, Status: http.StatusUnauthorized, Error: "The subscription was closed as the token is no longer valid."})
+		}
+	}()
+
+	return nil
+}
+
+// Close the subscription with the ID.
+func (s *socketSession) unsubscribe(id string) error {
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return huma.Error404NotFound(fmt.Sprintf("Failed to find subscription '%s'.", id))
+	}
+
+	subscription.Close()
+	delete(s.subscriptions, id)
+	return nil
+}
+
+// Read and perform requests until the client disconnects.
+func (s *socketSession) serve() {
+	ctx, cancel := context.WithCancel(s.conn.Request().Context())
+	defer func() {
+		cancel()
+		for _, subscription := range s.subscriptions {
+			subscription.Close()
+		}
+		s.wg.Wait()
+	}()
+
+	for {
+		data, payloadType, err := receiveSocketFrame(s.conn)
+		if err != nil {
+			log.Printf("User '%s' (%s) disconnected from WebSocket: %s\n", s.user.Name, s.user.Email, err)
+			return
+		}
+		codec := socketCodec{payloadType: payloadType}
+
+		// The token could have expired, or the user logged out or been changed, since the
+		// connection was opened.
+		user, err := s.authManager.Authenticate(s.token)
+		if err != nil {
+			s.send(codec, socketResponseFromError("", huma.Error401Unauthorized("Authentication failed.", err)))
+			return
+		}
+		s.user = user
+
+		var request SocketRequest
+		var response SocketResponse
+		if err := unmarshalSocketMessage(data, payloadType, &request); err != nil {
+			response = socketResponseFromError("", huma.Error400BadRequest("Cannot decode request.", err))
+		} else {
+			response = s.handle(ctx, codec, request)
+		}
+
+		if err := s.send(codec, response); err != nil {
+			log.Printf("Cannot respond to user '%s' (%s) over WebSocket: %s\n", s.user.Name, s.user.Email, err)
+			return
+		}
+	}
+}
+
+// Create a handler for the WebSocket API, authenticated by the same Bearer tokens as the
+// REST endpoints.
+func WebSocketHandler(authManager *auth.AuthManager, kvc keyValue.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := ParseBearerAuthorization(r.Header.Get("Authorization"))
+		if err != nil {
+			http.Error(w, "Authentication failed.", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := authManager.Authenticate(token)
+		if err != nil {
+			http.Error(w, "Authentication failed.", http.StatusUnauthorized)
+			return
+		}
+
+		websocket.Server{
+			Handler: func(conn *websocket.Conn) {
+				log.Printf("User '%s' (%s) connected to WebSocket.\n", user.Name, user.Email)
+
+				session := &socketSession{
+					conn:          conn,
+					authManager:   authManager,
+					kvc:           kvc,
+					token:         token,
+					user:          user,
+					subscriptions: make(map[string]*keyValue.Subscription),
+				}
+				session.serve()
+			},
+		}.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package keyValue
+
+import (
+	"github.com/google/uuid"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// KeyValueGrant shares an object with a specific user, who is not its owner.
+//
+// The grantee is treated as the owner of the object for the granted operations, so these are
+// still limited by their `Owned` privileges; e.g. a restricted user can be granted to read an
+// object, but a read-only user cannot be granted to update one.
+type KeyValueGrant struct {
+	Uuid   uuid.UUID `json:"uuid" doc:"The unique identifier of the user this grant is for."`
+	Email  string    `json:"email" doc:"The email of the user this grant is for."`
+	Select bool      `json:"read" doc:"Whether the user can read the object."`
+	Update bool      `json:"update" doc:"Whether the user can update the object."`
+	Delete bool      `json:"delete" doc:"Whether the user can delete the object."`
+}
+
+// Create a grant for the user, copying their details.
+func NewGrant(user *users.User, permissions users.Permissions) KeyValueGrant {
+	return KeyValueGrant{
+		Uuid:   user.Uuid,
+		Email:  user.Email,
+		Select: permissions.Select,
+		Update: permissions.Update,
+		Delete: permissions.Delete,
+	}
+}
+
+// Find the grant of the object for the user, if any.
+func (d *KeyValueDelivery) grantFor(user *users.User) (KeyValueGrant, bool) {
+	for _, grant := range d.Ownership.ACL {
+		if grant.Uuid == user.Uuid {
+			return grant, true
+		}
+	}
+	return KeyValueGrant{}, false
+}
+
+// Replace the access control list of the object, returning the list as stored.
+//
+// This requires the user to be allowed to share the object, i.e. to be its owner or to have
+// the `All.Update` privilege; the grants themselves do not allow changing the list. Grants for
+// the owner, or without any permissions, are discarded.
+func (kvc *KeyValueCache) SetACL(
+	key string,
+	acl []KeyValueGrant,
+	user *users.User,
+	conditions KeyValueConditions,
+) ([]KeyValueGrant, error) {
+	var stored []KeyValueGrant
+	err := kvc.LockAndDo(
+		key,
+		func(delivery *KeyValueDelivery) error {
+			if !delivery.canShare(key, user) {
+				return errorMessages.ErrNotPermitted
+			} else if err := conditions.check(delivery); err != nil {
+				return err
+			}
+
+			stored = delivery.normaliseACL(acl)
+			delivery.Ownership.ACL = stored
+			delivery.Ownership.LastModifiedBy = user.Email
+			return nil
+		},
+	)
+
+	return stored, err
+}
+
+// Remove the grants that have no effect, keeping the last grant for each user.
+func (d *KeyValueDelivery) normaliseACL(acl []KeyValueGrant) []KeyValueGrant {
+	normalised := make([]KeyValueGrant, 0, len(acl))
+	for _, grant := range acl {
+		if d.Ownership.Uuid != nil && grant.Uuid == *d.Ownership.Uuid {
+			continue
+		}
+
+		// Later grants for the same user replace the earlier ones.
+		for i, existing := range normalised {
+			if existing.Uuid == grant.Uuid {
+				normalised = append(normalised[:i], normalised[i+1:]...)
+				break
+			}
+		}
+		if grant.Select || grant.Update || grant.Delete {
+			normalised = append(normalised, grant)
+		}
+	}
+
+	if len(normalised) == 0 {
+		return nil
+	}
+	return normalised
+}
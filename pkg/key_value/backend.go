@@ -0,0 +1,361 @@
+package keyValue
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// Backend is the storage behind a `KeyValueCache`.
+//
+// The cache keeps the metadata of every object in memory and handles all the locking and
+// permission checks; the backend only needs to store and retrieve whole objects by key. The
+// backend will never be written to concurrently for the same key, but could be for different
+// keys.
+//
+// Previous versions of the objects are stored alongside them, keyed by their version; the
+// cache keeps their metadata in memory, and decides which of them need their values stored.
+type Backend interface {
+	// Read the value of the object stored under the key.
+	Read(key string) ([]byte, error)
+	// Store the object under the key, replacing any existing object.
+	Write(key string, delivery KeyValueDelivery) error
+	// Remove the object stored under the key.
+	Remove(key string) error
+	// List all the objects already in the backend, without their values.
+	Restore() (map[string]KeyValueDelivery, error)
+	// Store a previous version of the object under the key, along with its value if it has one.
+	WriteVersion(key string, version KeyValueVersion) error
+	// Read the value of a previous version of the object stored under the key.
+	ReadVersion(key string, version uint64) ([]byte, error)
+	// Remove a previous version of the object stored under the key.
+	RemoveVersion(key string, version uint64) error
+	// List all the previous versions already in the backend by key, without their values.
+	RestoreVersions() (map[string][]KeyValueVersion, error)
+}
+
+// KeyValueVersion is a previous version of an object, as stored by a `Backend`.
+//
+// Versions that only changed the metadata of an object, such as its access control list,
+// share the value of the version after them, so their values are not stored again.
+type KeyValueVersion struct {
+	Delivery KeyValueDelivery `json:"delivery"`
+	// Whether the value of this version is stored; if not, it is the same as the value of the
+	// next version.
+	HasValue bool `json:"hasValue,omitempty"`
+}
+
+// MemoryBackend is a `Backend` that keeps all values in a map; nothing is persisted.
+type MemoryBackend struct {
+	values   map[string][]byte
+	versions map[string]map[uint64][]byte
+	lock     sync.RWMutex
+}
+
+// Create a new, empty `MemoryBackend`.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		values:   make(map[string][]byte),
+		versions: make(map[string]map[uint64][]byte),
+	}
+}
+
+// Read the value stored under the key.
+func (b *MemoryBackend) Read(key string) ([]byte, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if value, ok := b.values[key]; ok {
+		return value, nil
+	}
+	return nil, errorMessages.ErrKeyNotFound
+}
+
+// Store the value of the object under the key; the rest of the object is kept by the cache.
+//
+// The value is copied, since the caller could reuse its buffer, e.g. for the next request body.
+func (b *MemoryBackend) Write(key string, delivery KeyValueDelivery) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.values[key] = slices.Clone(delivery.Value)
+	return nil
+}
+
+// Remove the value stored under the key.
+func (b *MemoryBackend) Remove(key string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.values, key)
+	return nil
+}
+
+// A new `MemoryBackend` is always empty.
+func (b *MemoryBackend) Restore() (map[string]KeyValueDelivery, error) {
+	return make(map[string]KeyValueDelivery), nil
+}
+
+// Store the value of a previous version of the object, if it has one; its metadata is kept by
+// the cache.
+func (b *MemoryBackend) WriteVersion(key string, version KeyValueVersion) error {
+	if !version.HasValue {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	versions, ok := b.versions[key]
+	if !ok {
+		versions = make(map[uint64][]byte)
+		b.versions[key] = versions
+	}
+	versions[version.Delivery.Version] = slices.Clone(version.Delivery.Value)
+	return nil
+}
+
+// Read the value of a previous version of the object.
+func (b *MemoryBackend) ReadVersion(key string, version uint64) ([]byte, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if value, ok := b.versions[key][version]; ok {
+		return value, nil
+	}
+	return nil, errorMessages.ErrVersionNotFound
+}
+
+// Remove the value of a previous version of the object.
+func (b *MemoryBackend) RemoveVersion(key string, version uint64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if versions, ok := b.versions[key]; ok {
+		delete(versions, version)
+		if len(versions) == 0 {
+			delete(b.versions, key)
+		}
+	}
+	return nil
+}
+
+// A new `MemoryBackend` never has any previous versions.
+func (b *MemoryBackend) RestoreVersions() (map[string][]KeyValueVersion, error) {
+	return make(map[string][]KeyValueVersion), nil
+}
+
+// The extensions of the files written by `FileBackend`, for the current and the previous
+// versions of the objects respectively.
+const (
+	fileBackendExtension        = ".ph"
+	fileBackendVersionExtension = ".phv"
+)
+
+// FileBackend is a `Backend` that stores each object in its own file within a directory,
+// so that values do not need to be kept in memory.
+//
+// Each file starts with a single line of JSON containing the key and the metadata of the
+// object, followed by the raw bytes of the value. Files are named after the SHA-256 digest of
+// their keys, since keys could contain characters that are not allowed in file names.
+//
+// Previous versions are stored in the same way, in files named after the digest of their keys
+// followed by their versions; those without values of their own have nothing after the header.
+type FileBackend struct {
+	Directory string
+}
+
+// fileBackendHeader is the first line of each file written by `FileBackend`.
+type fileBackendHeader struct {
+	Key      string           `json:"key"`
+	Delivery KeyValueDelivery `json:"delivery"`
+	HasValue bool             `json:"hasValue,omitempty"`
+}
+
+// Create a `FileBackend` in the directory, creating the directory if necessary.
+func NewFileBackend(directory string) (*FileBackend, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileBackend{Directory: directory}, nil
+}
+
+// Get the path of the file for the key.
+func (b *FileBackend) path(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(b.Directory, hex.EncodeToString(digest[:])+fileBackendExtension)
+}
+
+// Get the path of the file for a previous version of the key.
+func (b *FileBackend) versionPath(key string, version uint64) string {
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(b.Directory, hex.EncodeToString(digest[:])+"."+strconv.FormatUint(version, 10)+fileBackendVersionExtension)
+}
+
+// Read the value stored under the key.
+func (b *FileBackend) Read(key string) ([]byte, error) {
+	return readFileBackendValue(b.path(key), errorMessages.ErrKeyNotFound)
+}
+
+// Store the object under the key, replacing the file atomically.
+func (b *FileBackend) Write(key string, delivery KeyValueDelivery) error {
+	return writeFileBackendFile(b.path(key), fileBackendHeader{Key: key, Delivery: delivery})
+}
+
+// Remove the file of the key.
+func (b *FileBackend) Remove(key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Store a previous version of the object, replacing its file atomically.
+func (b *FileBackend) WriteVersion(key string, version KeyValueVersion) error {
+	delivery := version.Delivery
+	if !version.HasValue {
+		delivery.Value = nil
+	}
+
+	return writeFileBackendFile(
+		b.versionPath(key, delivery.Version),
+		fileBackendHeader{Key: key, Delivery: delivery, HasValue: version.HasValue},
+	)
+}
+
+// Read the value of a previous version of the object.
+func (b *FileBackend) ReadVersion(key string, version uint64) ([]byte, error) {
+	return readFileBackendValue(b.versionPath(key, version), errorMessages.ErrVersionNotFound)
+}
+
+// Remove the file of a previous version of the object.
+func (b *FileBackend) RemoveVersion(key string, version uint64) error {
+	if err := os.Remove(b.versionPath(key, version)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Read the headers of all the files of the current versions in the directory.
+//
+// Files that cannot be read are skipped with a warning, rather than failing the whole restore.
+func (b *FileBackend) Restore() (map[string]KeyValueDelivery, error) {
+	deliveries := make(map[string]KeyValueDelivery)
+	err := b.readHeaders(fileBackendExtension, func(header fileBackendHeader) {
+		deliveries[header.Key] = header.Delivery
+	})
+
+	return deliveries, err
+}
+
+// Read the headers of all the files of the previous versions in the directory, oldest first.
+//
+// Files that cannot be read are skipped with a warning, rather than failing the whole restore.
+func (b *FileBackend) RestoreVersions() (map[string][]KeyValueVersion, error) {
+	versions := make(map[string][]KeyValueVersion)
+	err := b.readHeaders(fileBackendVersionExtension, func(header fileBackendHeader) {
+		versions[header.Key] = append(versions[header.Key], KeyValueVersion{Delivery: header.Delivery, HasValue: header.HasValue})
+	})
+
+	for _, history := range versions {
+		slices.SortFunc(history, func(a, b KeyValueVersion) int {
+			return cmp.Compare(a.Delivery.Version, b.Delivery.Version)
+		})
+	}
+
+	return versions, err
+}
+
+// Read the headers of all the files in the directory with the extension.
+func (b *FileBackend) readHeaders(extension string, found func(fileBackendHeader)) error {
+	entries, err := os.ReadDir(b.Directory)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+
+		path := filepath.Join(b.Directory, entry.Name())
+		if header, err := readFileBackendHeader(path); err != nil {
+			log.Printf("Skipping unreadable file %s: %s\n", path, err)
+		} else {
+			found(header)
+		}
+	}
+
+	return nil
+}
+
+// Write a file of `FileBackend` atomically, with the header followed by the value.
+func writeFileBackendFile(path string, header fileBackendHeader) error {
+	value := header.Delivery.Value
+	header.Delivery.Value = nil
+
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(line) + 1 + len(value))
+	buffer.Write(line)
+	buffer.WriteByte('\n')
+	buffer.Write(value)
+
+	return writeFileAtomically(path, buffer.Bytes())
+}
+
+// Read the value of a file of `FileBackend`, returning `missing` if the file does not exist.
+func readFileBackendValue(path string, missing error) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, missing
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	// Skip the header.
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// Read the header line of a file written by `FileBackend`.
+func readFileBackendHeader(path string) (fileBackendHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fileBackendHeader{}, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return fileBackendHeader{}, err
+	}
+
+	var header fileBackendHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fileBackendHeader{}, err
+	}
+
+	return header, nil
+}
@@ -0,0 +1,62 @@
+package keyValue
+
+import (
+	"strconv"
+	"strings"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// KeyValueConditions are the preconditions of a request, in the form of the entity tags from
+// the `If-Match` and `If-None-Match` headers.
+//
+// The conditions are checked while the object is locked, so that they are atomic with the
+// operation they guard.
+type KeyValueConditions struct {
+	// The operation only proceeds if the object matches one of these entity tags; `*` matches
+	// any existing object.
+	IfMatch []string
+	// The operation only proceeds if the object matches none of these entity tags; `*` matches
+	// any existing object.
+	IfNoneMatch []string
+}
+
+// Get the entity tag of the object, derived from when it was created and its version.
+//
+// Versions start again at 1 when a key is deleted and created again, so the creation time is
+// included to tell the new object apart from the old one.
+func (d *KeyValueDelivery) ETag() string {
+	created := strconv.FormatInt(d.Timestamps.CreatedAt.UnixNano(), 36)
+	return strconv.Quote(created + "-" + strconv.FormatUint(d.Version, 10))
+}
+
+// Returns `true` if any of the entity tags match the object.
+//
+// Weak tags are compared as if they were strong, since the version of an object changes with
+// every modification.
+func (d *KeyValueDelivery) matchesAny(tags []string) bool {
+	etag := d.ETag()
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the conditions against the existing object, or against a missing object if `delivery`
+// is `nil`.
+//
+// Returns `ErrPreconditionFailed` if `IfMatch` is not satisfied, or `ErrNotModified` if
+// `IfNoneMatch` is not satisfied.
+func (c *KeyValueConditions) check(delivery *KeyValueDelivery) error {
+	if len(c.IfMatch) > 0 && (delivery == nil || !delivery.matchesAny(c.IfMatch)) {
+		return errorMessages.ErrPreconditionFailed
+	}
+	if len(c.IfNoneMatch) > 0 && delivery != nil && delivery.matchesAny(c.IfNoneMatch) {
+		return errorMessages.ErrNotModified
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package keyValue
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// EventKind is the kind of change that happened to a key.
+type EventKind string
+
+const (
+	// The key was created.
+	EventCreated EventKind = "created"
+	// The value or the settings of the key were changed.
+	EventUpdated EventKind = "updated"
+	// The key was deleted.
+	EventDeleted EventKind = "deleted"
+	// The key was collected by a recipient, or retrieved in `DeliveryModeOnce`.
+	EventDelivered EventKind = "delivered"
+	// The key was swept after its time-to-live had passed.
+	EventExpired EventKind = "expired"
+)
+
+// KeyValueEvent is a change that happened to a key, along with the metadata of the object
+// after the change; the value is never included.
+type KeyValueEvent struct {
+	Kind      EventKind `json:"kind" enum:"created,updated,deleted,delivered,expired" doc:"The kind of change."`
+	Timestamp time.Time `json:"timestamp" doc:"The time of the change."`
+	KeyValueListing
+}
+
+// The number of events buffered for each subscriber by default.
+const DefaultEventBufferSize = 64
+
+// OverflowPolicy governs what happens when a subscriber does not keep up with the events.
+type OverflowPolicy string
+
+const (
+	// Discard the events that do not fit in the buffer of the subscriber.
+	OverflowDrop OverflowPolicy = "drop"
+	// Close the subscription, so that the subscriber can reconnect and catch up.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// Parse an `OverflowPolicy` from its name.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowDrop, OverflowDisconnect:
+		return policy, nil
+	default:
+		return "", errorMessages.ErrUnknownOverflowPolicy
+	}
+}
+
+// Subscription receives the events of the keys with a prefix that its user is allowed to
+// read.
+//
+// The user is looked up again for every event, so that changes to their privileges take
+// effect on existing subscriptions; if the user can no longer be found, e.g. because their
+// token was revoked, the subscription is closed.
+type Subscription struct {
+	events     chan KeyValueEvent
+	lookup     func() (*users.User, error)
+	prefix     string
+	dropped    int
+	overflowed bool
+	revoked    bool
+	closed     bool
+	bus        *EventBus
+}
+
+// The channel of events; this is closed when the subscription ends.
+func (s *Subscription) Events() <-chan KeyValueEvent {
+	return s.events
+}
+
+// Get the number of events that had been dropped because the subscriber was too slow.
+func (s *Subscription) Dropped() int {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	return s.dropped
+}
+
+// Returns `true` if the subscription was closed because the subscriber fell behind.
+func (s *Subscription) Overflowed() bool {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	return s.overflowed
+}
+
+// Returns `true` if the subscription was closed because its user could no longer be found.
+func (s *Subscription) Revoked() bool {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	return s.revoked
+}
+
+// End the subscription; this is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	s.bus.unsubscribe(s)
+}
+
+// EventBus distributes the events of a `KeyValueCache` to its subscribers.
+//
+// Events are never blocked by slow subscribers: each subscriber has its own buffer, and the
+// `OverflowPolicy` decides what to do once that buffer is full.
+type EventBus struct {
+	subscriptions map[*Subscription]bool
+	bufferSize    int
+	policy        OverflowPolicy
+	lock          sync.Mutex
+}
+
+// Create a new event bus with no subscribers.
+func NewEventBus(bufferSize int, policy OverflowPolicy) *EventBus {
+	return &EventBus{
+		subscriptions: make(map[*Subscription]bool),
+		bufferSize:    bufferSize,
+		policy:        policy,
+	}
+}
+
+// Subscribe to the events of the keys starting with `prefix` that the user is allowed to read.
+//
+// Call `Close` on the subscription once it is no longer needed.
+func (b *EventBus) Subscribe(user *users.User, prefix string) *Subscription {
+	return b.SubscribeAs(func() (*users.User, error) { return user, nil }, prefix)
+}
+
+// Subscribe to the events of the keys starting with `prefix` that the user returned by `lookup`
+// at the time of each event is allowed to read; the subscription is closed once `lookup` fails.
+//
+// Call `Close` on the subscription once it is no longer needed.
+func (b *EventBus) SubscribeAs(lookup func() (*users.User, error), prefix string) *Subscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	subscription := &Subscription{
+		events: make(chan KeyValueEvent, b.bufferSize),
+		lookup: lookup,
+		prefix: prefix,
+		bus:    b,
+	}
+	b.subscriptions[subscription] = true
+
+	return subscription
+}
+
+// Remove the subscription and close its channel; the caller must hold the lock.
+func (b *EventBus) unsubscribe(subscription *Subscription) {
+	if !subscription.closed {
+		subscription.closed = true
+		delete(b.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Send an event about the key to all interested subscribers, without blocking.
+func (b *EventBus) publish(kind EventKind, key string, delivery KeyValueDelivery) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.subscriptions) == 0 {
+		return
+	}
+
+	event := KeyValueEvent{
+		Kind:            kind,
+		Timestamp:       time.Now().UTC(),
+		KeyValueListing: delivery.listing(key),
+	}
+
+	for subscription := range b.subscriptions {
+		if !strings.HasPrefix(key, subscription.prefix) {
+			continue
+		}
+
+		user, err := subscription.lookup()
+		if err != nil {
+			subscription.revoked = true
+			b.unsubscribe(subscription)
+			continue
+		} else if !delivery.canSelect(key, user) {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			subscription.dropped++
+			if b.policy == OverflowDisconnect {
+				subscription.overflowed = true
+				b.unsubscribe(subscription)
+			}
+		}
+	}
+}
+
+// Subscribe to the events of the keys starting with `prefix` that the user is allowed to read.
+func (kvc *KeyValueCache) Subscribe(user *users.User, prefix string) *Subscription {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	return kvc.events.Subscribe(user, prefix)
+}
+
+// Subscribe to the events of the keys starting with `prefix` that the user returned by `lookup`
+// at the time of each event is allowed to read.
+func (kvc *KeyValueCache) SubscribeAs(lookup func() (*users.User, error), prefix string) *Subscription {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	return kvc.events.SubscribeAs(lookup, prefix)
+}
+
+// Use the event bus for all subsequent events of the cache, e.g. to change its buffering.
+func (kvc *KeyValueCache) AttachEventBus(bus *EventBus) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	kvc.events = bus
+}
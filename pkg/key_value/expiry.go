@@ -0,0 +1,88 @@
+package keyValue
+
+import (
+	"log"
+	"time"
+)
+
+// Returns `true` if the object has an expiry time and it has passed.
+func (d *KeyValueDelivery) IsExpired() bool {
+	return !d.Timestamps.ExpiresAt.IsZero() && !d.Timestamps.ExpiresAt.After(time.Now())
+}
+
+// Returns the remaining time-to-live of the object, and whether the object expires at all.
+//
+// An object that had already expired will return a zero duration.
+func (d *KeyValueDelivery) TTL() (time.Duration, bool) {
+	if d.Timestamps.ExpiresAt.IsZero() {
+		return 0, false
+	}
+
+	return max(time.Until(d.Timestamps.ExpiresAt), 0), true
+}
+
+// Get the expiry time for an object created at `now`; zero if no TTL was specified.
+func (o KeyValueOptions) expiresAt(now time.Time) time.Time {
+	if o.TTL <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(o.TTL)
+}
+
+// Remove all expired objects from the cache, returning the number of objects removed.
+//
+// This locks the whole cache for writing while the sweep is in progress.
+func (kvc *KeyValueCache) Sweep() int {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	count := 0
+	for key, entry := range kvc.entries {
+		if entry.Delivery.IsExpired() {
+			if err := kvc.remove(key, entry.Delivery, EventExpired); err != nil {
+				log.Printf("Failed to remove expired key '%s': %s\n", key, err)
+				continue
+			}
+			count++
+		}
+	}
+
+	return count
+}
+
+// Start a background goroutine that sweeps expired objects from the cache at the
+// specified interval.
+//
+// Call the returned function to stop the sweeper.
+func (kvc *KeyValueCache) StartSweeper(interval time.Duration) func() {
+	return every(interval, func() {
+		if count := kvc.Sweep(); count > 0 {
+			log.Printf("Swept %d expired keys from the cache.\n", count)
+		}
+	})
+}
+
+// Start a background goroutine that performs the operation at the specified interval.
+//
+// Call the returned function to stop the goroutine.
+func every(interval time.Duration, operation func()) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				ticker.Stop()
+				return
+			case <-ticker.C:
+				operation()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,227 @@
+package keyValue
+
+import (
+	"bytes"
+	"log"
+	"slices"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// The number of previous versions kept for each key by default.
+const DefaultHistoryDepth = 10
+
+// Keep a previous version of the object in the history of the entry, discarding the oldest
+// versions beyond the history depth; the caller must hold the entry lock.
+//
+// Only the metadata of the version is kept in memory. Its value is stored by the backend, unless
+// it is the same as `current`, i.e. the value of the version after it, as is the case when only
+// the metadata of the object changed. This has to be called before the version after it is
+// committed; see `keep`.
+func (kvc *KeyValueCache) remember(key string, previous KeyValueDelivery, current []byte) (KeyValueVersion, error) {
+	version := KeyValueVersion{Delivery: previous, HasValue: !bytes.Equal(previous.Value, current)}
+	if kvc.historyDepth <= 0 {
+		return version, nil
+	}
+
+	return version, kvc.backend.WriteVersion(key, version)
+}
+
+// Add a version stored by `remember` to the history of the entry once the version after it had
+// been committed, discarding the oldest versions beyond the history depth; the caller must hold
+// the entry lock.
+func (kvc *KeyValueCache) keep(key string, entry *KeyValueEntry, version KeyValueVersion) {
+	if kvc.historyDepth <= 0 {
+		kvc.forget(key, entry.history)
+		entry.history = nil
+		return
+	}
+
+	version.Delivery.Value = nil
+	entry.history = append(entry.history, version)
+	if len(entry.history) > kvc.historyDepth {
+		// Newer versions never share the values of older ones, so these can be removed.
+		kvc.forget(key, entry.history[:len(entry.history)-kvc.historyDepth])
+		entry.history = slices.Clone(entry.history[len(entry.history)-kvc.historyDepth:])
+	}
+}
+
+// Remove the previous versions from the backend.
+//
+// Failures are only logged, since the versions are no longer reachable through the cache.
+func (kvc *KeyValueCache) forget(key string, versions []KeyValueVersion) {
+	for _, version := range versions {
+		if err := kvc.backend.RemoveVersion(key, version.Delivery.Version); err != nil {
+			log.Printf("Failed to remove version %d of key '%s': %s\n", version.Delivery.Version, key, err)
+		}
+	}
+}
+
+// Replace the history of the entry with previous versions along with their values, oldest
+// first; the caller must hold the entry lock.
+//
+// This is used to restore the history from a snapshot, in which every version has its value.
+func (kvc *KeyValueCache) restoreHistory(key string, entry *KeyValueEntry, deliveries []KeyValueDelivery) error {
+	kvc.forget(key, entry.history)
+	entry.history = nil
+	if kvc.historyDepth <= 0 {
+		return nil
+	}
+
+	current, err := kvc.backend.Read(key)
+	if err != nil {
+		return err
+	}
+
+	history := make([]KeyValueVersion, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		version, err := kvc.remember(key, deliveries[i], current)
+		if err != nil {
+			kvc.forget(key, history[i+1:])
+			return err
+		}
+
+		version.Delivery.Value = nil
+		history[i] = version
+		current = deliveries[i].Value
+	}
+	entry.history = history
+
+	return nil
+}
+
+// Find a previous version of the object in the history of the entry, along with its value; the
+// caller must hold the entry lock.
+func (kvc *KeyValueCache) version(key string, entry *KeyValueEntry, version uint64) (KeyValueDelivery, error) {
+	for i, previous := range entry.history {
+		if previous.Delivery.Version == version {
+			delivery := previous.Delivery
+			value, err := kvc.versionValue(key, entry, i)
+			if err != nil {
+				return KeyValueDelivery{}, err
+			}
+			delivery.Value = value
+
+			return delivery, nil
+		}
+	}
+	return KeyValueDelivery{}, errorMessages.ErrVersionNotFound
+}
+
+// Read the value of the previous version at `index` in the history of the entry, which could be
+// shared with any of the versions after it; the caller must hold the entry lock.
+func (kvc *KeyValueCache) versionValue(key string, entry *KeyValueEntry, index int) ([]byte, error) {
+	for _, previous := range entry.history[index:] {
+		if previous.HasValue {
+			return kvc.backend.ReadVersion(key, previous.Delivery.Version)
+		}
+	}
+	return kvc.backend.Read(key)
+}
+
+// Set the number of previous versions kept for each key.
+//
+// Existing histories are only trimmed when their keys are next updated.
+func (kvc *KeyValueCache) SetHistoryDepth(depth int) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	kvc.historyDepth = depth
+}
+
+// List the versions of the object, newest first, starting with the current version.
+//
+// This requires the user to be allowed to read the current version.
+func (kvc *KeyValueCache) Versions(key string, user *users.User) ([]KeyValueListing, error) {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	entry, ok := kvc.lookup(key)
+	if !ok {
+		return nil, errorMessages.ErrKeyNotFound
+	}
+
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	if !entry.Delivery.canSelect(key, user) {
+		return nil, errorMessages.ErrNotPermitted
+	}
+
+	listings := make([]KeyValueListing, 0, len(entry.history)+1)
+	listings = append(listings, entry.Delivery.listing(key))
+	for i := len(entry.history) - 1; i >= 0; i-- {
+		listings = append(listings, entry.history[i].Delivery.listing(key))
+	}
+
+	return listings, nil
+}
+
+// Fetch a version of the object.
+//
+// This requires the user to be allowed to read the current version. Fetching the current
+// version is the same as `GetValue`, i.e. objects in `DeliveryModeOnce` are removed.
+func (kvc *KeyValueCache) GetVersion(key string, version uint64, user *users.User) (KeyValueDelivery, error) {
+	delivery, isCurrent, err := kvc.findVersion(key, version, user)
+	if isCurrent {
+		return kvc.GetValue(key, user)
+	}
+
+	return delivery, err
+}
+
+// Find a previous version of the object, or report that it is the current version.
+func (kvc *KeyValueCache) findVersion(key string, version uint64, user *users.User) (KeyValueDelivery, bool, error) {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	entry, ok := kvc.lookup(key)
+	if !ok {
+		return KeyValueDelivery{}, false, errorMessages.ErrKeyNotFound
+	}
+
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+
+	if !entry.Delivery.canSelect(key, user) {
+		return KeyValueDelivery{}, false, errorMessages.ErrNotPermitted
+	} else if entry.Delivery.Version == version {
+		return KeyValueDelivery{}, true, nil
+	}
+
+	delivery, err := kvc.version(key, entry, version)
+	return delivery, false, err
+}
+
+// Restore the value of a previous version of the object as a new version.
+//
+// This requires the user to have permission to update the object. The settings of the
+// current version, such as its expiry and recipients, are retained.
+func (kvc *KeyValueCache) RestoreVersion(
+	key string,
+	version uint64,
+	user *users.User,
+	conditions KeyValueConditions,
+) error {
+	return kvc.LockAndDo(
+		key,
+		func(delivery *KeyValueDelivery) error {
+			if !delivery.canUpdate(key, user) {
+				return errorMessages.ErrNotPermitted
+			} else if err := conditions.check(delivery); err != nil {
+				return err
+			}
+
+			// `LockAndDo` holds the lock of the entry while we look through its history.
+			previous, err := kvc.version(key, kvc.entries[key], version)
+			if err != nil {
+				return err
+			}
+
+			delivery.Value = previous.Value
+			delivery.Ownership.LastModifiedBy = user.Email
+			return nil
+		},
+	)
+}
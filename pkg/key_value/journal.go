@@ -0,0 +1,418 @@
+package keyValue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// SyncPolicy governs how often the journal is flushed to disk.
+type SyncPolicy string
+
+const (
+	// Flush the journal to disk after every record; this is the slowest but safest option.
+	SyncAlways SyncPolicy = "always"
+	// Flush the journal to disk periodically; up to one interval of records could be lost.
+	SyncInterval SyncPolicy = "interval"
+	// Never explicitly flush the journal, leaving it to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+// Parse a `SyncPolicy` from its name.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(name); policy {
+	case SyncAlways, SyncInterval, SyncNever:
+		return policy, nil
+	default:
+		return "", errorMessages.ErrUnknownSyncPolicy
+	}
+}
+
+// JournalOperation is the kind of mutation recorded in the journal.
+type JournalOperation string
+
+const (
+	// The object under the key was created or replaced.
+	JournalPut JournalOperation = "put"
+	// The object under the key was removed.
+	JournalDelete JournalOperation = "delete"
+)
+
+// JournalRecord is a single mutation of the cache recorded in the journal.
+//
+// Records contain the full state of the object after the mutation, so that replaying the
+// same record more than once is harmless.
+type JournalRecord struct {
+	Operation JournalOperation  `json:"op"`
+	Key       string            `json:"key"`
+	Delivery  *KeyValueDelivery `json:"delivery,omitempty"`
+}
+
+// Each record is framed by its payload length and its CRC32 checksum.
+const journalHeaderSize = 8
+
+// Records larger than this are assumed to be corrupt.
+const journalMaxRecordSize = 1 << 30
+
+// Journal is an append-only write-ahead log of the mutations of a `KeyValueCache`.
+//
+// Once the journal grows past `maxSize`, it should be compacted into a snapshot at
+// `snapshotPath` by the cache; see `KeyValueCache.Compact`.
+type Journal struct {
+	file         *os.File
+	size         int64
+	maxSize      int64
+	policy       SyncPolicy
+	snapshotPath string
+	dirty        bool
+	// Set if a failed write could not be rolled back, so the end of the file is unknown.
+	broken      bool
+	compactions chan bool
+	lock        sync.Mutex
+}
+
+// Open the journal at `path`, returning it along with all the valid records it contains.
+//
+// If the journal ends with an incomplete or corrupt record, e.g. because the process was
+// killed mid-write, the journal is truncated to the last valid record.
+func OpenJournal(path string, snapshotPath string, policy SyncPolicy, maxSize int64) (*Journal, []JournalRecord, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffer, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	records, valid := decodeJournal(buffer)
+	if valid < int64(len(buffer)) {
+		log.Printf("Discarding %d bytes of corrupt records at the end of journal %s.\n", int64(len(buffer))-valid, path)
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &Journal{
+		file:         file,
+		size:         valid,
+		maxSize:      maxSize,
+		policy:       policy,
+		snapshotPath: snapshotPath,
+		compactions:  make(chan bool, 1),
+	}, records, nil
+}
+
+// Decode all the valid records in the buffer, returning them along with the length of the
+// buffer that they occupy.
+func decodeJournal(buffer []byte) ([]JournalRecord, int64) {
+	records := make([]JournalRecord, 0)
+	offset := 0
+
+	for len(buffer)-offset >= journalHeaderSize {
+		length := int(binary.BigEndian.Uint32(buffer[offset:]))
+		checksum := binary.BigEndian.Uint32(buffer[offset+4:])
+		start := offset + journalHeaderSize
+
+		if length > journalMaxRecordSize || len(buffer)-start < length {
+			break
+		}
+
+		payload := buffer[start : start+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		var record JournalRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			break
+		}
+
+		records = append(records, record)
+		offset = start + length
+	}
+
+	return records, int64(offset)
+}
+
+// Encode a record into its framed form.
+func encodeJournalRecord(record JournalRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	header := make([]byte, journalHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	buffer.Write(header)
+	buffer.Write(payload)
+
+	return buffer.Bytes(), nil
+}
+
+// Append a record to the journal.
+//
+// If the record cannot be written, the journal is rolled back to its previous size, so that a
+// partial record does not hide the records appended after it; if even that fails, the journal
+// refuses any further records until it is emptied by a compaction.
+//
+// This is a no-op if the journal is `nil`, so that caches without a journal need not check.
+func (j *Journal) Append(record JournalRecord) error {
+	if j == nil {
+		return nil
+	}
+
+	encoded, err := encodeJournalRecord(record)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.broken {
+		return errorMessages.ErrJournalWrite
+	}
+
+	if _, err := j.file.Write(encoded); err != nil {
+		log.Printf("Failed to append to journal %s: %s\n", j.file.Name(), err)
+		j.rollback()
+		return errorMessages.ErrJournalWrite
+	}
+	j.dirty = true
+
+	if j.policy == SyncAlways {
+		if err := j.sync(); err != nil {
+			log.Printf("Failed to sync journal %s: %s\n", j.file.Name(), err)
+			j.rollback()
+			return errorMessages.ErrJournalWrite
+		}
+	}
+	j.size += int64(len(encoded))
+
+	if j.maxSize > 0 && j.size > j.maxSize {
+		// Request a compaction without blocking; one pending request is enough.
+		select {
+		case j.compactions <- true:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Discard anything written past the size of the journal, e.g. a partial record; the caller
+// must hold the lock.
+//
+// The journal is marked as broken if this fails.
+func (j *Journal) rollback() {
+	if err := j.file.Truncate(j.size); err != nil {
+		log.Printf("Failed to roll back journal %s, refusing further records: %s\n", j.file.Name(), err)
+		j.broken = true
+		return
+	}
+	if _, err := j.file.Seek(j.size, io.SeekStart); err != nil {
+		log.Printf("Failed to roll back journal %s, refusing further records: %s\n", j.file.Name(), err)
+		j.broken = true
+	}
+}
+
+// Flush the journal to disk, if anything had been written since the last flush.
+func (j *Journal) Sync() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.sync()
+}
+
+// Flush the journal to disk without locking.
+func (j *Journal) sync() error {
+	if !j.dirty {
+		return nil
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.dirty = false
+
+	return nil
+}
+
+// Discard all records in the journal; this should only be done once they are captured
+// in a snapshot.
+func (j *Journal) truncate() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = 0
+	j.broken = false
+	j.dirty = true
+
+	return j.sync()
+}
+
+// Get the current size of the journal in bytes.
+func (j *Journal) Size() int64 {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.size
+}
+
+// Close the journal, flushing it to disk first.
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.sync(); err != nil {
+		return err
+	}
+
+	return j.file.Close()
+}
+
+// Apply the records to the cache, in order.
+//
+// This is a low level function that does not check any user permissions, nor does it
+// record the mutations in the journal.
+func (kvc *KeyValueCache) Replay(records []JournalRecord) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	for _, record := range records {
+		var err error
+
+		switch record.Operation {
+		case JournalPut:
+			if record.Delivery != nil && !record.Delivery.IsExpired() {
+				err = kvc.restore(record.Key, *record.Delivery)
+			} else {
+				err = kvc.discard(record.Key)
+			}
+		case JournalDelete:
+			err = kvc.discard(record.Key)
+		}
+
+		if err != nil {
+			log.Printf("Failed to replay journal record for key '%s': %s\n", record.Key, err)
+		}
+	}
+}
+
+// Record all subsequent mutations of the cache in the journal.
+func (kvc *KeyValueCache) AttachJournal(journal *Journal) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	kvc.journal = journal
+}
+
+// Stop recording mutations of the cache, and close the journal.
+func (kvc *KeyValueCache) CloseJournal() error {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	if kvc.journal == nil {
+		return errorMessages.ErrNoJournal
+	}
+
+	journal := kvc.journal
+	kvc.journal = nil
+
+	return journal.Close()
+}
+
+// Write a snapshot of the cache to the snapshot path of the journal, then discard all the
+// records in the journal.
+//
+// The whole cache is locked for writing during the compaction, so that no mutations can be
+// lost between the snapshot and the truncation.
+func (kvc *KeyValueCache) Compact() error {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	if kvc.journal == nil {
+		return errorMessages.ErrNoJournal
+	}
+
+	buffer, err := json.Marshal(kvc.snapshot())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Compacting journal into %d bytes of key-value data at %s...\n", len(buffer), kvc.journal.snapshotPath)
+	if err := writeFileAtomically(kvc.journal.snapshotPath, buffer); err != nil {
+		return err
+	}
+
+	return kvc.journal.truncate()
+}
+
+// Start a background goroutine that maintains the journal of the cache.
+//
+// The journal is compacted whenever it grows past its maximum size, as well as at every
+// `compactInterval`; if the `SyncInterval` policy is used, the journal is also flushed to
+// disk at every `syncInterval`. A journal must have been attached with `AttachJournal`.
+//
+// Call the returned function to stop the goroutine.
+func (kvc *KeyValueCache) StartJournal(compactInterval time.Duration, syncInterval time.Duration) func() {
+	journal := kvc.journal
+
+	compact := func() {
+		if err := kvc.Compact(); err != nil {
+			log.Printf("Failed to compact journal: %s\n", err)
+		}
+	}
+
+	stopCompactions := every(compactInterval, compact)
+	stopSyncs := func() {}
+	if journal.policy == SyncInterval {
+		stopSyncs = every(syncInterval, func() {
+			if err := journal.Sync(); err != nil {
+				log.Printf("Failed to sync journal: %s\n", err)
+			}
+		})
+	}
+
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-journal.compactions:
+				compact()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		stopSyncs()
+		stopCompactions()
+	}
+}
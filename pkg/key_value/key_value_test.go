@@ -1,9 +1,16 @@
 package keyValue
 
 import (
+	"context"
 	"crypto/rand"
+	"os"
+	"path/filepath"
 	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 
@@ -308,3 +315,1595 @@ func TestKeyValueCacheWithPermissions(t *testing.T) {
 		t.Errorf("Expected %d length, got %d", curLength+3, kvc.Length())
 	}
 }
+
+func TestKeyValueCacheExpiry(t *testing.T) {
+	kvc := NewCache()
+
+	user := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	// Insert one key that expires shortly, and one that never expires
+	if err := kvc.PutValueWithOptions("shortLived", secret, &user, &user, KeyValueOptions{TTL: 50 * time.Millisecond}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutValue("longLived", secret, &user); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// Check the remaining TTL is reported
+	if delivery, err := kvc.GetValue("shortLived", &user); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if ttl, ok := delivery.TTL(); !ok || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Errorf("Expected a TTL of up to 50ms, got %v (expires: %t)", ttl, ok)
+	}
+	if delivery, err := kvc.GetValue("longLived", &user); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if _, ok := delivery.TTL(); ok {
+		t.Errorf("Expected no TTL for a key without expiry")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The expired key should no longer be retrievable, even before it is swept
+	if _, err := kvc.GetValue("shortLived", &user); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected '%s' for expired key, got '%s'`, errorMessages.ErrKeyNotFound, err)
+	}
+	if err := kvc.UpdateValue("shortLived", secret, &user); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected '%s' updating expired key, got '%s'`, errorMessages.ErrKeyNotFound, err)
+	}
+	if kvc.Length() != 2 {
+		t.Errorf("Expected 2 length before sweeping, got %d", kvc.Length())
+	}
+
+	// Sweep the expired key
+	if count := kvc.Sweep(); count != 1 {
+		t.Errorf("Expected 1 key swept, got %d", count)
+	}
+	if kvc.Length() != 1 {
+		t.Errorf("Expected 1 length after sweeping, got %d", kvc.Length())
+	}
+
+	// Re-insert the expired key, and check that the background sweeper removes it
+	if err := kvc.PutValueWithOptions("shortLived", secret, &user, &user, KeyValueOptions{TTL: 10 * time.Millisecond}); err != nil {
+		t.Errorf(`Expected no error re-inserting expired key, got '%s'`, err)
+	}
+	stop := kvc.StartSweeper(20 * time.Millisecond)
+	defer stop()
+
+	time.Sleep(100 * time.Millisecond)
+	if kvc.Length() != 1 {
+		t.Errorf("Expected 1 length after background sweep, got %d", kvc.Length())
+	}
+
+	// Updating with a TTL should set the expiry on an existing key
+	if err := kvc.UpdateValueWithOptions("longLived", secret, &user, KeyValueOptions{TTL: time.Hour}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if delivery, err := kvc.GetValue("longLived", &user); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if _, ok := delivery.TTL(); !ok {
+		t.Errorf("Expected a TTL after updating with one")
+	}
+}
+
+func TestKeyValueCacheReadOnce(t *testing.T) {
+	kvc := NewCache()
+
+	sender := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	recipient := users.NewUser(
+		"Sarah",
+		"sarah@test.com",
+		users.StandardUser(),
+	)
+	restrictedUser := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValueWithOptions("oneTimeSecret", secret, &sender, &sender, KeyValueOptions{Mode: DeliveryModeOnce}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// A user without permission should not be able to collect the key
+	if _, err := kvc.GetValue("oneTimeSecret", &restrictedUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error collecting with restricted, got '%s'`, err)
+	}
+	if kvc.Length() != 1 {
+		t.Errorf("Expected 1 length after failed collection, got %d", kvc.Length())
+	}
+
+	// The first retrieval should succeed and stamp the delivery time
+	if delivery, err := kvc.GetValue("oneTimeSecret", &recipient); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else {
+		if !slices.Equal(delivery.Value, secret) {
+			t.Errorf("Expected secret, got %v", delivery.Value)
+		}
+		if delivery.Timestamps.DeliveredAt.IsZero() {
+			t.Errorf("Expected DeliveredAt to be stamped")
+		}
+	}
+
+	// The second retrieval should fail
+	if _, err := kvc.GetValue("oneTimeSecret", &sender); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected '%s' on second retrieval, got '%s'`, errorMessages.ErrKeyNotFound, err)
+	}
+	if kvc.Length() != 0 {
+		t.Errorf("Expected 0 length after collection, got %d", kvc.Length())
+	}
+
+	// Persistent keys should be retained after retrieval
+	if err := kvc.PutValue("persistentSecret", secret, &sender); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	for range 2 {
+		if _, err := kvc.GetValue("persistentSecret", &recipient); err != nil {
+			t.Errorf(`Expected no error, got '%s'`, err)
+		}
+	}
+
+	// Concurrent readers should never both receive the same key
+	const readers = 16
+	for round := range 20 {
+		if err := kvc.PutValueWithOptions("racedSecret", secret, &sender, &sender, KeyValueOptions{Mode: DeliveryModeOnce}); err != nil {
+			t.Fatalf(`Expected no error in round %d, got '%s'`, round, err)
+		}
+
+		results := make(chan error, readers)
+		var wg sync.WaitGroup
+		for range readers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := kvc.GetValue("racedSecret", &recipient)
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		successes := 0
+		for err := range results {
+			if err == nil {
+				successes++
+			} else if !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+				t.Errorf(`Expected no error or '%s', got '%s'`, errorMessages.ErrKeyNotFound, err)
+			}
+		}
+		if successes != 1 {
+			t.Errorf("Expected exactly 1 successful collection in round %d, got %d", round, successes)
+		}
+	}
+}
+
+func TestKeyValueCacheRecipients(t *testing.T) {
+	kvc := NewCache()
+
+	sender := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	recipient := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+	bystander := users.NewUser(
+		"Sarah",
+		"sarah@test.com",
+		users.StandardUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValueWithOptions("forBob", secret, &sender, &sender, KeyValueOptions{Recipients: []string{"Bob@Test.com", "bob@test.com"}}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutValue("forAnyone", secret, &sender); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// The recipient can read the key despite lacking `All.Select`
+	if delivery, err := kvc.GetValue("forBob", &recipient); err != nil {
+		t.Errorf(`Expected no error reading addressed key as recipient, got '%s'`, err)
+	} else if !slices.Equal(delivery.Ownership.Recipients, []string{"bob@test.com"}) {
+		t.Errorf("Expected normalised recipients, got %v", delivery.Ownership.Recipients)
+	}
+	if _, err := kvc.GetValue("forAnyone", &recipient); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading unaddressed key as restricted, got '%s'`, err)
+	}
+
+	// Other users can read the key with `All.Select` only, and the owner can read it too
+	outsider := users.NewUser(
+		"Carol",
+		"carol@test.com",
+		users.RestrictedUser(),
+	)
+	if _, err := kvc.GetValue("forBob", &outsider); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading addressed key as outsider, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("forBob", &bystander); err != nil {
+		t.Errorf(`Expected no error reading addressed key with "All.Select", got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("forBob", &sender); err != nil {
+		t.Errorf(`Expected no error reading addressed key as owner, got '%s'`, err)
+	}
+
+	// Only the recipient sees the key in their pigeon hole
+	if listings := kvc.AddressedTo(&recipient); len(listings) != 1 || listings[0].Key != "forBob" {
+		t.Errorf("Expected only 'forBob' to be addressed to recipient, got %v", listings)
+	}
+	if listings := kvc.AddressedTo(&bystander); len(listings) != 0 {
+		t.Errorf("Expected nothing to be addressed to bystander, got %v", listings)
+	}
+
+	// The bystander cannot collect the key, but the recipient can
+	if _, err := kvc.DeleteValue("forBob", &bystander); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error collecting addressed key as bystander, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("forBob", &recipient); err != nil {
+		t.Errorf(`Expected no error collecting addressed key as recipient, got '%s'`, err)
+	}
+	if listings := kvc.AddressedTo(&recipient); len(listings) != 0 {
+		t.Errorf("Expected pigeon hole to be empty after collection, got %v", listings)
+	}
+}
+
+func TestKeyValueCacheRestrictedRecipient(t *testing.T) {
+	kvc := NewCache()
+
+	sender := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	recipient := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.StandardUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValueWithOptions("forBob", secret, &sender, &sender, KeyValueOptions{Recipients: []string{"bob@test.com"}}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// An API key without any privileges cannot read, list or collect what is addressed to its user
+	restricted := recipient
+	restricted.Restriction = &users.Privileges{}
+	if _, err := kvc.GetValue("forBob", &restricted); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading addressed key with a restricted API key, got '%s'`, err)
+	}
+	if listings := kvc.AddressedTo(&restricted); len(listings) != 0 {
+		t.Errorf("Expected nothing listed for a restricted API key, got %v", listings)
+	}
+	if _, err := kvc.DeleteValue("forBob", &restricted); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error collecting addressed key with a restricted API key, got '%s'`, err)
+	}
+
+	// An API key that can read its own objects can read, but not collect, the key
+	restricted.Restriction = &users.Privileges{Owned: users.Permissions{Select: true}}
+	if _, err := kvc.GetValue("forBob", &restricted); err != nil {
+		t.Errorf(`Expected no error reading addressed key with a read-only API key, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("forBob", &restricted); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error collecting addressed key with a read-only API key, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("forBob", &recipient); err != nil {
+		t.Errorf(`Expected no error collecting addressed key as recipient, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCachePersistence(t *testing.T) {
+	kvc := NewCache()
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValueWithOptions("mySecret", secret, &owner, &owner, KeyValueOptions{Recipients: []string{"bob@test.com"}}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOptions("expiredSecret", secret, &owner, &owner, KeyValueOptions{TTL: time.Millisecond}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := kvc.ExportTo(path); err != nil {
+		t.Fatalf(`Expected no error exporting, got '%s'`, err)
+	}
+
+	restored, err := ImportFromOrNew(path)
+	if err != nil {
+		t.Fatalf(`Expected no error importing, got '%s'`, err)
+	}
+
+	// Expired keys should not be persisted
+	if restored.Length() != 1 {
+		t.Errorf("Expected 1 length after restoring, got %d", restored.Length())
+	}
+
+	original, _ := kvc.Get("mySecret")
+	if delivery, err := restored.Get("mySecret"); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else {
+		if !slices.Equal(delivery.Value, secret) {
+			t.Errorf("Expected secret, got %v", delivery.Value)
+		}
+		if !delivery.Timestamps.CreatedAt.Equal(original.Timestamps.CreatedAt) {
+			t.Errorf("Expected CreatedAt %v, got %v", original.Timestamps.CreatedAt, delivery.Timestamps.CreatedAt)
+		}
+		if !slices.Equal(delivery.Ownership.Recipients, original.Ownership.Recipients) {
+			t.Errorf("Expected recipients %v, got %v", original.Ownership.Recipients, delivery.Ownership.Recipients)
+		}
+	}
+
+	// The owner should be recognised again by a fresh copy of the user, as after a reload
+	reloaded := users.UserWithUuid(owner.Uuid, owner.Name, owner.Email, owner.Privileges)
+	if err := restored.UpdateValue("mySecret", secret, &reloaded); err != nil {
+		t.Errorf(`Expected no error updating after restoring, got '%s'`, err)
+	}
+
+	// A missing file should result in an empty cache
+	if empty, err := ImportFromOrNew(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf(`Expected no error importing missing file, got '%s'`, err)
+	} else if empty.Length() != 0 {
+		t.Errorf("Expected 0 length for missing file, got %d", empty.Length())
+	}
+}
+
+func TestKeyValueCacheJournal(t *testing.T) {
+	directory := t.TempDir()
+	dataPath := filepath.Join(directory, "data.json")
+	journalPath := filepath.Join(directory, "journal.log")
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	secret1 := make([]byte, 32)
+	rand.Read(secret1)
+	secret2 := make([]byte, 32)
+	rand.Read(secret2)
+
+	kvc := NewCache()
+	journal, records, err := OpenJournal(journalPath, dataPath, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf(`Expected no error opening journal, got '%s'`, err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected no records in a new journal, got %d", len(records))
+	}
+	kvc.AttachJournal(journal)
+
+	// Perform a series of mutations
+	if err := kvc.PutValue("myKey1", secret1, &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutValue("myKey2", secret1, &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("myKey1", secret2, &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("myKey2", &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutOrUpdate("myKey3", KeyValueDelivery{Value: secret2}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.CloseJournal(); err != nil {
+		t.Errorf(`Expected no error closing journal, got '%s'`, err)
+	}
+
+	// Simulate a record that was only partially written before the process died
+	file, _ := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	file.Close()
+
+	// Replay the journal into a new cache
+	replayed := NewCache()
+	journal, records, err = OpenJournal(journalPath, dataPath, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf(`Expected no error reopening journal, got '%s'`, err)
+	}
+	if len(records) != 5 {
+		t.Errorf("Expected 5 records, got %d", len(records))
+	}
+	replayed.Replay(records)
+	replayed.AttachJournal(journal)
+
+	if replayed.Length() != 2 {
+		t.Errorf("Expected 2 length after replaying, got %d", replayed.Length())
+	}
+	if delivery, err := replayed.Get("myKey1"); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if !slices.Equal(delivery.Value, secret2) {
+		t.Errorf("Expected updated secret2, got %v", delivery.Value)
+	}
+	if _, err := replayed.Get("myKey2"); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected deleted key to be not found, got '%s'`, err)
+	}
+
+	// The corrupt record should have been truncated, so new records are readable
+	if err := replayed.Put("myKey4", KeyValueDelivery{Value: secret1}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// Compacting should write the data file and empty the journal
+	if err := replayed.Compact(); err != nil {
+		t.Errorf(`Expected no error compacting, got '%s'`, err)
+	}
+	if size := journal.Size(); size != 0 {
+		t.Errorf("Expected empty journal after compaction, got %d bytes", size)
+	}
+	if err := replayed.Delete("myKey3"); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	replayed.CloseJournal()
+
+	// Restore from the data file and the remaining journal
+	restored, err := ImportFrom(dataPath)
+	if err != nil {
+		t.Fatalf(`Expected no error importing, got '%s'`, err)
+	}
+	journal, records, err = OpenJournal(journalPath, dataPath, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf(`Expected no error reopening journal, got '%s'`, err)
+	}
+	defer journal.Close()
+	if len(records) != 1 {
+		t.Errorf("Expected 1 record after compaction, got %d", len(records))
+	}
+	restored.Replay(records)
+
+	if restored.Length() != 2 {
+		t.Errorf("Expected 2 length after restoring, got %d", restored.Length())
+	}
+	for _, key := range []string{"myKey1", "myKey4"} {
+		if _, err := restored.Get(key); err != nil {
+			t.Errorf(`Expected no error getting '%s', got '%s'`, key, err)
+		}
+	}
+}
+
+func TestJournalFailedWrite(t *testing.T) {
+	directory := t.TempDir()
+	journalPath := filepath.Join(directory, "journal.log")
+
+	journal, _, err := OpenJournal(journalPath, filepath.Join(directory, "data.json"), SyncNever, 0)
+	if err != nil {
+		t.Fatalf(`Expected no error opening journal, got '%s'`, err)
+	}
+	if err := journal.Append(JournalRecord{Operation: JournalDelete, Key: "myKey1"}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	size := journal.Size()
+
+	// A read-only file can neither be written to nor rolled back
+	file := journal.file
+	journal.file, _ = os.Open(journalPath)
+	if err := journal.Append(JournalRecord{Operation: JournalDelete, Key: "myKey2"}); !errorMessages.Matches(err, errorMessages.ErrJournalWrite) {
+		t.Errorf(`Expected ErrJournalWrite, got '%s'`, err)
+	}
+	journal.file.Close()
+	journal.file = file
+
+	// The journal should refuse further records, even once the file is writable again
+	if err := journal.Append(JournalRecord{Operation: JournalDelete, Key: "myKey3"}); !errorMessages.Matches(err, errorMessages.ErrJournalWrite) {
+		t.Errorf(`Expected ErrJournalWrite on a broken journal, got '%s'`, err)
+	}
+	if journal.Size() != size {
+		t.Errorf("Expected size %d after failed writes, got %d", size, journal.Size())
+	}
+
+	// Emptying the journal should make it usable again
+	if err := journal.truncate(); err != nil {
+		t.Errorf(`Expected no error truncating, got '%s'`, err)
+	}
+	if err := journal.Append(JournalRecord{Operation: JournalDelete, Key: "myKey4"}); err != nil {
+		t.Errorf(`Expected no error after truncating, got '%s'`, err)
+	}
+	journal.Close()
+
+	if _, records, err := OpenJournal(journalPath, filepath.Join(directory, "data.json"), SyncNever, 0); err != nil {
+		t.Errorf(`Expected no error reopening journal, got '%s'`, err)
+	} else if len(records) != 1 || records[0].Key != "myKey4" {
+		t.Errorf("Expected only the record of myKey4, got %v", records)
+	}
+}
+
+func TestKeyValueCacheFileBackend(t *testing.T) {
+	directory := t.TempDir()
+
+	backend, err := NewFileBackend(directory)
+	if err != nil {
+		t.Fatalf(`Expected no error creating backend, got '%s'`, err)
+	}
+	kvc, err := NewCacheWithBackend(backend)
+	if err != nil {
+		t.Fatalf(`Expected no error creating cache, got '%s'`, err)
+	}
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	// Values could contain newlines, which should not confuse the header.
+	secret[0] = '\n'
+
+	if err := kvc.PutValueWithOptions("my/secret", []byte("old"), &owner, &owner, KeyValueOptions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.UpdateValueWithOptions("my/secret", secret, &owner, KeyValueOptions{}); err != nil {
+		t.Errorf(`Expected no error updating, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOptions("deleted", secret, &owner, &owner, KeyValueOptions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("deleted", &owner); err != nil {
+		t.Errorf(`Expected no error deleting, got '%s'`, err)
+	}
+
+	// The key and its previous version should be left
+	if files, _ := filepath.Glob(filepath.Join(directory, "*"+fileBackendExtension)); len(files) != 1 {
+		t.Errorf("Expected 1 key file in the directory, got %d", len(files))
+	}
+	if files, _ := filepath.Glob(filepath.Join(directory, "*"+fileBackendVersionExtension)); len(files) != 1 {
+		t.Errorf("Expected 1 version file in the directory, got %d", len(files))
+	}
+
+	// A new cache on the same directory should find the remaining key
+	reopened, err := NewCacheWithBackend(backend)
+	if err != nil {
+		t.Fatalf(`Expected no error reopening cache, got '%s'`, err)
+	}
+	if reopened.Length() != 1 {
+		t.Errorf("Expected 1 length after reopening, got %d", reopened.Length())
+	}
+	if delivery, err := reopened.Get("my/secret"); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if !slices.Equal(delivery.Value, secret) {
+		t.Errorf("Expected secret, got %v", delivery.Value)
+	}
+	if _, err := reopened.Get("deleted"); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected "ErrKeyNotFound" error, got '%s'`, err)
+	}
+
+	// The history should survive reopening
+	if delivery, err := reopened.GetVersion("my/secret", 1, &owner); err != nil || string(delivery.Value) != "old" {
+		t.Errorf("Expected 'old' after reopening, got '%s' with error '%v'", delivery.Value, err)
+	}
+}
+
+func TestKeyValueCacheList(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	restrictedUser := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+
+	for _, key := range []string{"b/3", "a/1", "b/1", "b/2", "c/1"} {
+		if err := kvc.PutValueWithOptions(key, []byte(key), &owner, &owner, KeyValueOptions{}); err != nil {
+			t.Errorf(`Expected no error, got '%s'`, err)
+		}
+	}
+	if err := kvc.PutValueWithOptions("b/0", []byte("secret"), &owner, &owner, KeyValueOptions{Recipients: []string{"alice@test.com"}}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOptions("b/4", []byte("expired"), &owner, &owner, KeyValueOptions{TTL: time.Millisecond}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	keysOf := func(page KeyValuePage) []string {
+		keys := make([]string, 0, len(page.Keys))
+		for _, listing := range page.Keys {
+			keys = append(keys, listing.Key)
+		}
+		return keys
+	}
+
+	// The first page should be in lexical order, skipping expired keys
+	page := kvc.List(&owner, "b/", "", 2)
+	if expected := []string{"b/0", "b/1"}; !slices.Equal(keysOf(page), expected) {
+		t.Errorf("Expected %v, got %v", expected, keysOf(page))
+	}
+	if page.NextCursor != "b/1" {
+		t.Errorf("Expected cursor 'b/1', got '%s'", page.NextCursor)
+	}
+	if page.Keys[1].Size != 3 {
+		t.Errorf("Expected size 3, got %d", page.Keys[1].Size)
+	}
+
+	page = kvc.List(&owner, "b/", page.NextCursor, 2)
+	if expected := []string{"b/2", "b/3"}; !slices.Equal(keysOf(page), expected) {
+		t.Errorf("Expected %v, got %v", expected, keysOf(page))
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Expected no cursor on the last page, got '%s'", page.NextCursor)
+	}
+
+	// A non-positive limit should fall back to the default rather than panic
+	if page := kvc.List(&owner, "b/", "", 0); len(page.Keys) != 4 {
+		t.Errorf("Expected 4 keys with the default limit, got %v", keysOf(page))
+	}
+
+	// Users should not see the keys they cannot read
+	if page := kvc.List(&restrictedUser, "", "", 100); len(page.Keys) != 0 {
+		t.Errorf("Expected no keys for a restricted user, got %v", keysOf(page))
+	}
+}
+
+func TestKeyValueCacheConditions(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	if err := kvc.PutValue("mySecret", []byte("first"), &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	first, _ := kvc.Get("mySecret")
+	if first.Version != 1 {
+		t.Errorf("Expected version 1, got %d", first.Version)
+	}
+
+	// An update with the current ETag should succeed and increment the version
+	options := KeyValueOptions{Conditions: KeyValueConditions{IfMatch: []string{first.ETag()}}}
+	if err := kvc.UpdateValueWithOptions("mySecret", []byte("second"), &owner, options); err != nil {
+		t.Errorf(`Expected no error updating with matching ETag, got '%s'`, err)
+	}
+	second, _ := kvc.Get("mySecret")
+	if second.Version != 2 || second.ETag() == first.ETag() {
+		t.Errorf("Expected version 2 with a new ETag, got %d with %s", second.Version, second.ETag())
+	}
+
+	// The stale ETag should now be rejected by all writes, without changing anything
+	if err := kvc.UpdateValueWithOptions("mySecret", []byte("third"), &owner, options); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error updating, got '%s'`, err)
+	}
+	if err := kvc.PutOrUpdateValueWithOptions("mySecret", []byte("third"), &owner, options); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error upserting, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValueWithConditions("mySecret", &owner, options.Conditions); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error deleting, got '%s'`, err)
+	}
+	if delivery, _ := kvc.Get("mySecret"); string(delivery.Value) != "second" || delivery.Version != 2 {
+		t.Errorf("Expected the object to be unchanged, got '%s' at version %d", delivery.Value, delivery.Version)
+	}
+
+	// An upsert that has to match cannot create a new key
+	if err := kvc.PutOrUpdateValueWithOptions("newSecret", []byte("new"), &owner, options); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error upserting a new key, got '%s'`, err)
+	}
+
+	// Reads should only be skipped if the ETag matches
+	if _, err := kvc.GetValueWithConditions("mySecret", &owner, KeyValueConditions{IfNoneMatch: []string{second.ETag()}}); !errorMessages.Matches(err, errorMessages.ErrNotModified) {
+		t.Errorf(`Expected "ErrNotModified" error, got '%s'`, err)
+	}
+	if _, err := kvc.GetValueWithConditions("mySecret", &owner, KeyValueConditions{IfNoneMatch: []string{"W/" + first.ETag()}}); err != nil {
+		t.Errorf(`Expected no error with a stale ETag, got '%s'`, err)
+	}
+
+	if _, err := kvc.DeleteValueWithConditions("mySecret", &owner, KeyValueConditions{IfMatch: []string{"*"}}); err != nil {
+		t.Errorf(`Expected no error deleting with a wildcard, got '%s'`, err)
+	}
+
+	// A key created again starts at version 1, but must not match the ETags of the old object
+	if err := kvc.PutValue("mySecret", []byte("first"), &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	recreated, _ := kvc.Get("mySecret")
+	if recreated.Version != first.Version || recreated.ETag() == first.ETag() {
+		t.Errorf("Expected version %d with a new ETag, got %d with %s", first.Version, recreated.Version, recreated.ETag())
+	}
+	if _, err := kvc.GetValueWithConditions("mySecret", &owner, KeyValueConditions{IfNoneMatch: []string{first.ETag()}}); err != nil {
+		t.Errorf(`Expected no error with the ETag of the deleted object, got '%s'`, err)
+	}
+	if err := kvc.UpdateValueWithOptions("mySecret", []byte("second"), &owner, KeyValueOptions{Conditions: KeyValueConditions{IfMatch: []string{first.ETag()}}}); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error updating with the ETag of the deleted object, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCachePutOrUpdate(t *testing.T) {
+	kvc := NewCache()
+
+	group := KeyValueGroup{Uuid: uuid.New(), Name: "backend"}
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	owner.Groups = []uuid.UUID{group.Uuid}
+	editor := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.Privileges{All: users.Permissions{Select: true, Update: true}},
+	)
+	nobody := users.NewUser(
+		"Carol",
+		"carol@test.com",
+		users.Privileges{},
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValue("mySecret", secret, &owner); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+
+	// Users who cannot insert should not learn whether the key exists
+	if err := kvc.PutValueWithOptions("mySecret", secret, &nobody, &nobody, KeyValueOptions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting an existing key without privileges, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOptions("otherSecret", secret, &nobody, &nobody, KeyValueOptions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting a new key without privileges, got '%s'`, err)
+	}
+
+	// Users who can only update the key can still upsert it, but not create new keys
+	if err := kvc.PutOrUpdateValue("mySecret", []byte("updated"), &editor); err != nil {
+		t.Errorf(`Expected no error upserting an existing key as an editor, got '%s'`, err)
+	}
+	if err := kvc.PutOrUpdateValue("otherSecret", secret, &editor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error upserting a new key as an editor, got '%s'`, err)
+	}
+
+	// Upserting with a group the user is not in should not fall back to updating without it
+	if err := kvc.PutOrUpdateValueWithOptions("mySecret", secret, &editor, KeyValueOptions{Group: &group}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error upserting with the group of others, got '%s'`, err)
+	}
+	if err := kvc.PutOrUpdateValueWithOptions("groupSecret", secret, &owner, KeyValueOptions{Group: &group}); err != nil {
+		t.Errorf(`Expected no error upserting a new key as a member, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCacheWait(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	// A waiter should be woken up when the key is created
+	results := make(chan KeyValueDelivery)
+	go func() {
+		delivery, err := kvc.WaitForValue(context.Background(), "mySecret", &owner, KeyValueConditions{})
+		if err != nil {
+			t.Errorf(`Expected no error waiting, got '%s'`, err)
+		}
+		results <- delivery
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := kvc.PutValue("mySecret", []byte("first"), &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	select {
+	case delivery := <-results:
+		if string(delivery.Value) != "first" {
+			t.Errorf("Expected 'first', got '%s'", delivery.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be woken up by the creation")
+	}
+
+	// A waiter with a matching ETag should be woken up when the key changes
+	current, _ := kvc.Get("mySecret")
+	go func() {
+		delivery, err := kvc.WaitForValue(context.Background(), "mySecret", &owner, KeyValueConditions{IfNoneMatch: []string{current.ETag()}})
+		if err != nil {
+			t.Errorf(`Expected no error waiting, got '%s'`, err)
+		}
+		results <- delivery
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := kvc.UpdateValue("mySecret", []byte("second"), &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	select {
+	case delivery := <-results:
+		if string(delivery.Value) != "second" {
+			t.Errorf("Expected 'second', got '%s'", delivery.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be woken up by the update")
+	}
+
+	// Cancelling the context should release the waiter
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := kvc.WaitForValue(ctx, "otherSecret", &owner, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected "ErrKeyNotFound" error after cancelling, got '%s'`, err)
+	}
+
+	kvc.changes.lock.Lock()
+	if len(kvc.changes.waiters) != 0 {
+		t.Errorf("Expected no waiters left, got %d", len(kvc.changes.waiters))
+	}
+	kvc.changes.lock.Unlock()
+}
+
+func TestKeyValueCacheEvents(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	restrictedUser := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+
+	subscription := kvc.Subscribe(&owner, "jobs/")
+	defer subscription.Close()
+	restricted := kvc.Subscribe(&restrictedUser, "")
+	defer restricted.Close()
+
+	kvc.PutValueWithOptions("jobs/1", []byte("first"), &owner, &owner, KeyValueOptions{TTL: time.Millisecond})
+	kvc.PutValue("other", []byte("ignored"), &owner)
+	kvc.PutValueWithOptions("jobs/2", []byte("first"), &owner, &owner, KeyValueOptions{Mode: DeliveryModeOnce})
+	kvc.UpdateValue("jobs/2", []byte("second"), &owner)
+	kvc.GetValue("jobs/2", &owner)
+	kvc.PutValue("jobs/3", []byte("first"), &owner)
+	kvc.DeleteValue("jobs/3", &owner)
+	time.Sleep(10 * time.Millisecond)
+	kvc.Sweep()
+
+	expected := []struct {
+		kind EventKind
+		key  string
+	}{
+		{EventCreated, "jobs/1"},
+		{EventCreated, "jobs/2"},
+		{EventUpdated, "jobs/2"},
+		{EventDelivered, "jobs/2"},
+		{EventCreated, "jobs/3"},
+		{EventDeleted, "jobs/3"},
+		{EventExpired, "jobs/1"},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-subscription.Events():
+			if event.Kind != want.kind || event.Key != want.key {
+				t.Errorf("Expected %s event for '%s', got %s event for '%s'", want.kind, want.key, event.Kind, event.Key)
+			}
+		default:
+			t.Fatalf("Expected %s event for '%s', got nothing", want.kind, want.key)
+		}
+	}
+	if len(subscription.Events()) != 0 {
+		t.Errorf("Expected no more events, got %d", len(subscription.Events()))
+	}
+
+	// Users should not receive the events of keys they cannot read
+	if len(restricted.Events()) != 0 {
+		t.Errorf("Expected no events for a restricted user, got %d", len(restricted.Events()))
+	}
+
+	// Subscriptions should follow the user as currently looked up, until it cannot be found
+	current, err := &restrictedUser, error(nil)
+	lookedUp := kvc.SubscribeAs(func() (*users.User, error) { return current, err }, "jobs/")
+	defer lookedUp.Close()
+
+	kvc.PutValue("jobs/4", []byte("first"), &owner)
+	current = &owner
+	kvc.UpdateValue("jobs/4", []byte("second"), &owner)
+	err = errorMessages.ErrTokenInvalid
+	kvc.UpdateValue("jobs/4", []byte("third"), &owner)
+
+	if event, ok := <-lookedUp.Events(); !ok || event.Kind != EventUpdated || event.Version != 2 {
+		t.Errorf("Expected the update to version 2 once the user changed, got %+v", event)
+	}
+	if _, ok := <-lookedUp.Events(); ok || !lookedUp.Revoked() {
+		t.Errorf("Expected the subscription to be revoked once the user cannot be found")
+	}
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+
+	for _, policy := range []OverflowPolicy{OverflowDrop, OverflowDisconnect} {
+		kvc := NewCache()
+		kvc.AttachEventBus(NewEventBus(2, policy))
+		subscription := kvc.Subscribe(&owner, "")
+
+		for i := 0; i < 4; i++ {
+			kvc.PutOrUpdateValue("myKey", []byte{byte(i)}, &owner)
+		}
+
+		received := 0
+		for range subscription.Events() {
+			received++
+			if received == 2 {
+				break
+			}
+		}
+
+		switch policy {
+		case OverflowDrop:
+			// The subscription should still receive new events
+			kvc.PutOrUpdateValue("myKey", []byte{4}, &owner)
+			if event, open := <-subscription.Events(); !open || event.Version != 5 {
+				t.Errorf("Expected the subscription to remain open, got open=%t with version %d", open, event.Version)
+			}
+			if subscription.Dropped() != 2 {
+				t.Errorf("Expected 2 dropped events, got %d", subscription.Dropped())
+			}
+		case OverflowDisconnect:
+			if _, open := <-subscription.Events(); open {
+				t.Errorf("Expected the subscription to be closed")
+			}
+		}
+
+		subscription.Close()
+	}
+}
+
+func TestKeyValueCacheHistory(t *testing.T) {
+	kvc := NewCache()
+	kvc.SetHistoryDepth(2)
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	restrictedUser := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+
+	// Reuse the same buffer for every value, as the request bodies could be
+	buffer := []byte("v1")
+	kvc.PutValue("mySecret", buffer, &owner)
+	for _, value := range []string{"v2", "v3", "v4"} {
+		copy(buffer, value)
+		if err := kvc.UpdateValue("mySecret", buffer, &owner); err != nil {
+			t.Errorf(`Expected no error, got '%s'`, err)
+		}
+	}
+
+	// Only the current version and the 2 previous versions should be kept
+	versions, err := kvc.Versions("mySecret", &owner)
+	if err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	listed := make([]uint64, 0, len(versions))
+	for _, listing := range versions {
+		listed = append(listed, listing.Version)
+	}
+	if expected := []uint64{4, 3, 2}; !slices.Equal(listed, expected) {
+		t.Errorf("Expected versions %v, got %v", expected, listed)
+	}
+
+	if delivery, err := kvc.GetVersion("mySecret", 2, &owner); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if string(delivery.Value) != "v2" {
+		t.Errorf("Expected 'v2', got '%s'", delivery.Value)
+	}
+	if _, err := kvc.GetVersion("mySecret", 1, &owner); !errorMessages.Matches(err, errorMessages.ErrVersionNotFound) {
+		t.Errorf(`Expected "ErrVersionNotFound" error, got '%s'`, err)
+	}
+	if _, err := kvc.GetVersion("mySecret", 2, &restrictedUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error, got '%s'`, err)
+	}
+
+	// Restoring requires update permission, and creates a new version
+	if err := kvc.RestoreVersion("mySecret", 2, &restrictedUser, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error restoring, got '%s'`, err)
+	}
+	if err := kvc.RestoreVersion("mySecret", 2, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error restoring, got '%s'`, err)
+	}
+	if delivery, _ := kvc.Get("mySecret"); string(delivery.Value) != "v2" || delivery.Version != 5 {
+		t.Errorf("Expected 'v2' at version 5, got '%s' at version %d", delivery.Value, delivery.Version)
+	}
+
+	// Changing only the metadata should not store the value again
+	if _, err := kvc.SetACL("mySecret", []KeyValueGrant{NewGrant(&restrictedUser, users.Permissions{Select: true})}, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error setting the ACL, got '%s'`, err)
+	}
+	backend := kvc.backend.(*MemoryBackend)
+	if _, err := backend.ReadVersion("mySecret", 5); !errorMessages.Matches(err, errorMessages.ErrVersionNotFound) {
+		t.Errorf(`Expected "ErrVersionNotFound" error reading the value of a metadata-only version, got '%s'`, err)
+	}
+	if delivery, err := kvc.GetVersion("mySecret", 5, &owner); err != nil || string(delivery.Value) != "v2" {
+		t.Errorf("Expected 'v2' for version 5, got '%s' with error '%v'", delivery.Value, err)
+	}
+
+	// The history should survive a snapshot
+	restored := FromSnapshot(kvc.Snapshot())
+	if delivery, err := restored.GetVersion("mySecret", 4, &owner); err != nil || string(delivery.Value) != "v4" {
+		t.Errorf("Expected 'v4' after restoring from snapshot, got '%s' with error '%v'", delivery.Value, err)
+	}
+	if delivery, err := restored.GetVersion("mySecret", 5, &owner); err != nil || string(delivery.Value) != "v2" {
+		t.Errorf("Expected 'v2' for version 5 after restoring from snapshot, got '%s' with error '%v'", delivery.Value, err)
+	}
+
+	// Deleting the key should remove its history from the backend
+	if _, err := kvc.DeleteValue("mySecret", &owner); err != nil {
+		t.Errorf(`Expected no error deleting, got '%s'`, err)
+	}
+	if versions := len(backend.versions); versions != 0 {
+		t.Errorf("Expected no versions left in the backend, got %d keys", versions)
+	}
+}
+
+func TestKeyValueCacheMetadata(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.StandardUser(),
+	)
+	admin := users.NewUser(
+		"Alice",
+		"alice@test.com",
+		users.AdminUser(),
+	)
+	restrictedUser := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+
+	if err := kvc.PutValue("mySecret", []byte("v1"), &owner); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	created, err := kvc.Metadata("mySecret", &owner)
+	if err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	if created.Value != nil {
+		t.Errorf("Expected no value in the metadata, got '%s'", created.Value)
+	}
+	if created.Timestamps.UpdatedAt != created.Timestamps.CreatedAt {
+		t.Errorf("Expected UpdatedAt to equal CreatedAt, got %v and %v", created.Timestamps.UpdatedAt, created.Timestamps.CreatedAt)
+	}
+	if created.Ownership.LastModifiedBy != owner.Email {
+		t.Errorf("Expected LastModifiedBy to be '%s', got '%s'", owner.Email, created.Ownership.LastModifiedBy)
+	}
+	if !created.Timestamps.AccessedAt.IsZero() {
+		t.Errorf("Expected AccessedAt to be zero, got %v", created.Timestamps.AccessedAt)
+	}
+
+	// Updating should keep the creation time, and record who made the update
+	if err := kvc.UpdateValue("mySecret", []byte("v2"), &admin); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	updated, _ := kvc.Metadata("mySecret", &owner)
+	if updated.Timestamps.CreatedAt != created.Timestamps.CreatedAt {
+		t.Errorf("Expected CreatedAt to be unchanged, got %v instead of %v", updated.Timestamps.CreatedAt, created.Timestamps.CreatedAt)
+	}
+	if !updated.Timestamps.UpdatedAt.After(created.Timestamps.UpdatedAt) {
+		t.Errorf("Expected UpdatedAt to advance, got %v", updated.Timestamps.UpdatedAt)
+	}
+	if updated.Ownership.LastModifiedBy != admin.Email {
+		t.Errorf("Expected LastModifiedBy to be '%s', got '%s'", admin.Email, updated.Ownership.LastModifiedBy)
+	}
+
+	// Reading should stamp the access time without changing the version
+	if _, err := kvc.GetValue("mySecret", &owner); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	accessed, _ := kvc.Metadata("mySecret", &owner)
+	if accessed.Timestamps.AccessedAt.IsZero() {
+		t.Errorf("Expected AccessedAt to be stamped")
+	}
+	if accessed.Version != updated.Version {
+		t.Errorf("Expected version %d, got %d", updated.Version, accessed.Version)
+	}
+
+	if _, err := kvc.Metadata("mySecret", &restrictedUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error, got '%s'`, err)
+	}
+	if _, err := kvc.Metadata("myMissingKey", &owner); !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+		t.Errorf(`Expected "ErrKeyNotFound" error, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCacheOwnership(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.RestrictedUser(),
+	)
+	// A fresh copy of the owner, as loaded from the user list after a restart
+	sameOwner := users.UserWithUuid(owner.Uuid, "Steven", "Steve@Test.com", users.RestrictedUser())
+	// Another user registered with the same email after the owner was removed
+	impostor := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.RestrictedUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValue("mySecret", secret, &owner); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+
+	// Owned privileges should hold for any copy of the owner
+	if _, err := kvc.GetValue("mySecret", &sameOwner); err != nil {
+		t.Errorf(`Expected no error reading as a copy of the owner, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &sameOwner); err != nil {
+		t.Errorf(`Expected no error updating as a copy of the owner, got '%s'`, err)
+	}
+
+	// ...but not for anyone else, even with the same email
+	if _, err := kvc.GetValue("mySecret", &impostor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading as another user, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &impostor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating as another user, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("mySecret", &impostor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error deleting as another user, got '%s'`, err)
+	}
+
+	// Inserting on behalf of a copy of yourself only requires the owned privilege
+	if err := kvc.PutValueWithOwner("myOtherSecret", secret, &sameOwner, &owner); err != nil {
+		t.Errorf(`Expected no error inserting for a copy of the owner, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOwner("myStolenSecret", secret, &impostor, &owner); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error inserting for another user, got '%s'`, err)
+	}
+
+	// Objects persisted without the UUID of their owner are resolved by email
+	snapshot := kvc.Snapshot()
+	legacy := snapshot.Contents["mySecret"]
+	legacy.Ownership.Uuid = nil
+	snapshot.Contents["mySecret"] = legacy
+	restored := FromSnapshot(snapshot)
+
+	if err := restored.UpdateValue("mySecret", secret, &sameOwner); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating before resolving, got '%s'`, err)
+	}
+	lookup := func(email string) (*users.User, error) {
+		if email == owner.Email {
+			return &sameOwner, nil
+		}
+		return nil, errorMessages.ErrUserNotFound
+	}
+	if orphaned := restored.ResolveOwners(lookup); orphaned != 0 {
+		t.Errorf("Expected no orphaned keys, got %d", orphaned)
+	}
+	if err := restored.UpdateValue("mySecret", secret, &owner); err != nil {
+		t.Errorf(`Expected no error updating after resolving, got '%s'`, err)
+	}
+
+	// Owners replaced by another user with the same email are orphaned
+	impostorLookup := func(email string) (*users.User, error) {
+		return &impostor, nil
+	}
+	if orphaned := restored.ResolveOwners(impostorLookup); orphaned != 2 {
+		t.Errorf("Expected 2 orphaned keys, got %d", orphaned)
+	}
+	if err := restored.UpdateValue("mySecret", secret, &impostor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating as another user after resolving, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCacheTransfer(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.RestrictedUser(),
+	)
+	colleague := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+	admin := users.NewUser(
+		"Alice",
+		"alice@test.com",
+		users.AdminUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	for _, key := range []string{"mySecret1", "mySecret2", "mySecret3"} {
+		if err := kvc.PutValueWithOptions(key, secret, &owner, &owner, KeyValueOptions{Recipients: []string{"carol@test.com"}}); err != nil {
+			t.Fatalf(`Expected no error, got '%s'`, err)
+		}
+	}
+	kvc.PutValue("otherSecret", secret, &colleague)
+
+	// Only the owner, or an admin, can transfer a key
+	if err := kvc.TransferValue("mySecret1", &colleague, &colleague, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error, got '%s'`, err)
+	}
+	if err := kvc.TransferValue("mySecret1", &colleague, &owner, KeyValueConditions{IfMatch: []string{`"9"`}}); !errorMessages.Matches(err, errorMessages.ErrPreconditionFailed) {
+		t.Errorf(`Expected "ErrPreconditionFailed" error, got '%s'`, err)
+	}
+	if err := kvc.TransferValue("mySecret1", &colleague, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+
+	// The new owner takes over, and the recipients are retained
+	if err := kvc.UpdateValue("mySecret1", secret, &owner); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating as the previous owner, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret1", secret, &colleague); err != nil {
+		t.Errorf(`Expected no error updating as the new owner, got '%s'`, err)
+	}
+	if delivery, _ := kvc.Get("mySecret1"); !slices.Equal(delivery.Ownership.Recipients, []string{"carol@test.com"}) || *delivery.Ownership.Email != colleague.Email {
+		t.Errorf("Expected the key to be owned by '%s' and addressed to 'carol@test.com', got %+v", colleague.Email, delivery.Ownership)
+	}
+
+	// Users cannot transfer the keys of others in bulk without the `All.Update` privilege
+	if _, err := kvc.TransferValues(owner.Uuid, &colleague, &colleague); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error, got '%s'`, err)
+	}
+	if transferred, err := kvc.TransferValues(owner.Uuid, &colleague, &admin); err != nil || transferred != 2 {
+		t.Errorf(`Expected 2 keys transferred without error, got %d with error '%v'`, transferred, err)
+	}
+	if _, err := kvc.DeleteValue("mySecret3", &colleague); err != nil {
+		t.Errorf(`Expected no error deleting as the new owner, got '%s'`, err)
+	}
+
+	// Keys are matched by the UUID of their owner, not by their email
+	replacement := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+	if transferred, err := kvc.Transfer(replacement.Uuid, &owner); err != nil || transferred != 0 {
+		t.Errorf(`Expected no keys transferred for another user with the same email, got %d with error '%v'`, transferred, err)
+	}
+	if transferred, err := kvc.Transfer(colleague.Uuid, &owner); err != nil || transferred != 3 {
+		t.Errorf(`Expected 3 keys transferred without error, got %d with error '%v'`, transferred, err)
+	}
+
+	// The keys of a removed user can be recovered by their email, but only by an admin
+	if _, err := kvc.TransferValuesByEmail("Steve@test.com", &colleague, &colleague); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error, got '%s'`, err)
+	}
+	if transferred, err := kvc.TransferValuesByEmail("Steve@test.com", &colleague, &admin); err != nil || transferred != 3 {
+		t.Errorf(`Expected 3 keys transferred by email without error, got %d with error '%v'`, transferred, err)
+	}
+	if delivery, _ := kvc.Get("otherSecret"); *delivery.Ownership.Uuid != colleague.Uuid || delivery.Ownership.LastModifiedBy != admin.Email {
+		t.Errorf("Expected the key to be owned by '%s' and modified by '%s', got %+v", colleague.Email, admin.Email, delivery.Ownership)
+	}
+}
+
+func TestKeyValueCacheACL(t *testing.T) {
+	kvc := NewCache()
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.RestrictedUser(),
+	)
+	reader := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+	editor := users.NewUser(
+		"Carol",
+		"carol@test.com",
+		users.RestrictedUser(),
+	)
+	readOnlyUser := users.NewUser(
+		"Dave",
+		"dave@test.com",
+		users.ReadOnlyUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValue("mySecret", secret, &owner); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("mySecret", &reader); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error before sharing, got '%s'`, err)
+	}
+
+	// Only the owner can share the key
+	acl := []KeyValueGrant{
+		NewGrant(&reader, users.ReadOnlyPermissions()),
+		NewGrant(&editor, users.Permissions{Select: true, Update: true}),
+		NewGrant(&readOnlyUser, users.FullPermissions()),
+		NewGrant(&owner, users.FullPermissions()),
+		NewGrant(&reader, users.ReadOnlyPermissions()),
+	}
+	if _, err := kvc.SetACL("mySecret", acl, &reader, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error sharing as another user, got '%s'`, err)
+	}
+	if stored, err := kvc.SetACL("mySecret", acl, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	} else if len(stored) != 3 {
+		t.Errorf("Expected the grant for the owner and the duplicate to be discarded, got %+v", stored)
+	}
+
+	// Each grantee can do what they are granted, limited by their owned privileges
+	if _, err := kvc.GetValue("mySecret", &reader); err != nil {
+		t.Errorf(`Expected no error reading as a reader, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &reader); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating as a reader, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &editor); err != nil {
+		t.Errorf(`Expected no error updating as an editor, got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("mySecret", &editor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error deleting as an editor, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &readOnlyUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating as a read-only user, got '%s'`, err)
+	}
+
+	// Grants do not allow sharing further, nor taking over the key
+	if _, err := kvc.SetACL("mySecret", nil, &editor, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error sharing as an editor, got '%s'`, err)
+	}
+	if err := kvc.TransferValue("mySecret", &editor, &editor, KeyValueConditions{}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error transferring as an editor, got '%s'`, err)
+	}
+
+	// Grants apply to addressed keys as well
+	if err := kvc.PutValueWithOptions("myMessage", secret, &owner, &owner, KeyValueOptions{Recipients: []string{"dave@test.com"}}); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	kvc.SetACL("myMessage", []KeyValueGrant{NewGrant(&reader, users.ReadOnlyPermissions())}, &owner, KeyValueConditions{})
+	if _, err := kvc.GetValue("myMessage", &reader); err != nil {
+		t.Errorf(`Expected no error reading an addressed key as a reader, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("myMessage", &editor); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading an addressed key without a grant, got '%s'`, err)
+	}
+
+	// Transferring the key to a grantee discards their grant
+	if err := kvc.TransferValue("mySecret", &editor, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if delivery, _ := kvc.Get("mySecret"); len(delivery.Ownership.ACL) != 2 {
+		t.Errorf("Expected 2 grants after transferring, got %+v", delivery.Ownership.ACL)
+	}
+
+	// Clearing the list stops sharing the key
+	if _, err := kvc.SetACL("mySecret", nil, &editor, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("mySecret", &reader); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error after unsharing, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCacheGroupOwnership(t *testing.T) {
+	kvc := NewCache()
+
+	group := KeyValueGroup{Uuid: uuid.New(), Name: "backend"}
+
+	owner := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		users.RestrictedUser(),
+	)
+	owner.Groups = []uuid.UUID{group.Uuid}
+	member := users.NewUser(
+		"Bob",
+		"bob@test.com",
+		users.RestrictedUser(),
+	)
+	member.Groups = []uuid.UUID{group.Uuid}
+	outsider := users.NewUser(
+		"Carol",
+		"carol@test.com",
+		users.RestrictedUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	// Only members can create keys owned by the group
+	if err := kvc.PutValueWithOptions("mySecret", secret, &outsider, &outsider, KeyValueOptions{Group: &group}); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting as an outsider, got '%s'`, err)
+	}
+	if err := kvc.PutValueWithOptions("mySecret", secret, &owner, &owner, KeyValueOptions{Group: &group}); err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+
+	// Every member receives the owned privileges
+	if _, err := kvc.GetValue("mySecret", &member); err != nil {
+		t.Errorf(`Expected no error reading as a member, got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("mySecret", secret, &member); err != nil {
+		t.Errorf(`Expected no error updating as a member, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("mySecret", &outsider); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading as an outsider, got '%s'`, err)
+	}
+
+	// Leaving the group revokes the privileges, while the owner keeps theirs
+	member.Groups = nil
+	if err := kvc.UpdateValue("mySecret", secret, &member); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error updating after leaving the group, got '%s'`, err)
+	}
+	owner.Groups = nil
+	if err := kvc.UpdateValue("mySecret", secret, &owner); err != nil {
+		t.Errorf(`Expected no error updating as the owner, got '%s'`, err)
+	}
+
+	// The group survives a transfer and a snapshot
+	if err := kvc.TransferValue("mySecret", &outsider, &owner, KeyValueConditions{}); err != nil {
+		t.Errorf(`Expected no error, got '%s'`, err)
+	}
+	restored := FromSnapshot(kvc.Snapshot())
+	member.Groups = []uuid.UUID{group.Uuid}
+	if _, err := restored.DeleteValue("mySecret", &member); err != nil {
+		t.Errorf(`Expected no error deleting as a member after restoring, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCacheScopes(t *testing.T) {
+	kvc := NewCache()
+
+	privileges := users.StandardUser()
+	privileges.Scopes = []string{"team/", "shared/"}
+	scopedUser := users.NewUser(
+		"Steve",
+		"steve@test.com",
+		privileges,
+	)
+	admin := users.NewUser(
+		"Alice",
+		"alice@test.com",
+		users.AdminUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	// Scoped users can only write within their scopes
+	if err := kvc.PutValue("team/mySecret", secret, &scopedUser); err != nil {
+		t.Errorf(`Expected no error putting within scope, got '%s'`, err)
+	}
+	if err := kvc.PutValue("mySecret", secret, &scopedUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting outside scope, got '%s'`, err)
+	}
+
+	// ...and read within their scopes, despite the `All.Select` privilege
+	kvc.PutValue("otherSecret", secret, &admin)
+	kvc.PutValue("shared/otherSecret", secret, &admin)
+	if _, err := kvc.GetValue("shared/otherSecret", &scopedUser); err != nil {
+		t.Errorf(`Expected no error reading within scope, got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("otherSecret", &scopedUser); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading outside scope, got '%s'`, err)
+	}
+	if page := kvc.List(&scopedUser, "", "", 10); len(page.Keys) != 2 {
+		t.Errorf("Expected 2 keys listed within scope, got %d", len(page.Keys))
+	}
+
+	// Recipients can still collect keys addressed to them outside their scopes
+	kvc.PutValueWithOptions("addressedSecret", secret, &admin, &admin, KeyValueOptions{Recipients: []string{scopedUser.Email}})
+	if _, err := kvc.DeleteValue("addressedSecret", &scopedUser); err != nil {
+		t.Errorf(`Expected no error collecting an addressed key outside scope, got '%s'`, err)
+	}
+}
+
+func TestKeyValueCachePrefixRules(t *testing.T) {
+	kvc := NewCache()
+
+	// A CI robot that can only write under `ci/` and read under `artifacts/`
+	robot := users.NewUser(
+		"CI",
+		"ci@test.com",
+		users.Privileges{
+			Owned: users.NoPermissions(),
+			All:   users.NoPermissions(),
+			Rules: []users.PrefixRule{
+				{Prefix: "ci/", Owned: users.FullPermissions(), All: users.NoPermissions()},
+				{Prefix: "artifacts/", Owned: users.NoPermissions(), All: users.ReadOnlyPermissions()},
+				{Prefix: "ci/locked/", Owned: users.ReadOnlyPermissions(), All: users.NoPermissions()},
+			},
+		},
+	)
+	admin := users.NewUser(
+		"Alice",
+		"alice@test.com",
+		users.AdminUser(),
+	)
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	if err := kvc.PutValue("ci/build", secret, &robot); err != nil {
+		t.Errorf(`Expected no error putting under 'ci/', got '%s'`, err)
+	}
+	if err := kvc.UpdateValue("ci/build", secret, &robot); err != nil {
+		t.Errorf(`Expected no error updating under 'ci/', got '%s'`, err)
+	}
+	if err := kvc.PutValue("artifacts/build", secret, &robot); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting under 'artifacts/', got '%s'`, err)
+	}
+	if err := kvc.PutValue("mySecret", secret, &robot); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting without a rule, got '%s'`, err)
+	}
+
+	// The longest prefix wins
+	if err := kvc.PutValue("ci/locked/build", secret, &robot); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error putting under 'ci/locked/', got '%s'`, err)
+	}
+
+	kvc.PutValue("artifacts/release", secret, &admin)
+	kvc.PutValue("ci/admin", secret, &admin)
+	if _, err := kvc.GetValue("artifacts/release", &robot); err != nil {
+		t.Errorf(`Expected no error reading under 'artifacts/', got '%s'`, err)
+	}
+	if _, err := kvc.GetValue("ci/admin", &robot); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error reading keys of others under 'ci/', got '%s'`, err)
+	}
+	if _, err := kvc.DeleteValue("artifacts/release", &robot); !errorMessages.Matches(err, errorMessages.ErrNotPermitted) {
+		t.Errorf(`Expected "ErrNotPermitted" error deleting under 'artifacts/', got '%s'`, err)
+	}
+}
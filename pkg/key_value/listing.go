@@ -0,0 +1,101 @@
+package keyValue
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// KeyValueListing is a summary of a key-value object, without its value.
+type KeyValueListing struct {
+	Key        string             `json:"key" doc:"The key of the object."`
+	Mode       DeliveryMode       `json:"mode,omitempty" enum:"persistent,once" doc:"The delivery mode of this object."`
+	Size       int                `json:"size" doc:"The size of the value in bytes."`
+	Version    uint64             `json:"version" doc:"The version of this object, incremented with every modification."`
+	Timestamps KeyValueTimestamps `json:"timestamps" doc:"The timestamps associated with this object."`
+	Ownership  KeyValueOwnership  `json:"ownedBy"`
+}
+
+// Create a listing of the object under the specified key.
+func (d *KeyValueDelivery) listing(key string) KeyValueListing {
+	return KeyValueListing{
+		Key:        key,
+		Mode:       d.Mode,
+		Size:       d.Size,
+		Version:    d.Version,
+		Timestamps: d.Timestamps,
+		Ownership:  d.Ownership,
+	}
+}
+
+// List all the unexpired objects addressed to the user that they are allowed to read, sorted by
+// key.
+func (kvc *KeyValueCache) AddressedTo(user *users.User) []KeyValueListing {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	listings := make([]KeyValueListing, 0)
+	for key, entry := range kvc.entries {
+		delivery := entry.metadata()
+		if !delivery.IsExpired() && delivery.IsAddressedTo(user) && delivery.canSelect(key, user) {
+			listings = append(listings, delivery.listing(key))
+		}
+	}
+
+	slices.SortFunc(listings, func(a, b KeyValueListing) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return listings
+}
+
+// KeyValuePage is a page of listings, in lexical order of their keys.
+type KeyValuePage struct {
+	Keys []KeyValueListing `json:"keys" doc:"The keys on this page, without their values."`
+	// The cursor is the last key on the page, so that pages remain stable even if keys are
+	// inserted or deleted between requests.
+	NextCursor string `json:"nextCursor,omitempty" doc:"Pass this as the cursor to fetch the next page; absent if this is the last page."`
+}
+
+// The number of keys listed on each page if no positive limit is specified.
+const DefaultListLimit = 100
+
+// List the unexpired objects whose keys start with `prefix` and sort after `cursor`, up to
+// `limit` of them, in lexical order of their keys; `DefaultListLimit` is used if `limit` is
+// not positive.
+//
+// Only the objects that the user is allowed to read are listed, so that users never learn
+// of keys they cannot retrieve.
+func (kvc *KeyValueCache) List(user *users.User, prefix string, cursor string, limit int) KeyValuePage {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range kvc.entries {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	page := KeyValuePage{Keys: make([]KeyValueListing, 0)}
+	for _, key := range keys {
+		delivery := kvc.entries[key].metadata()
+		if delivery.IsExpired() || !delivery.canSelect(key, user) {
+			continue
+		}
+
+		if len(page.Keys) >= limit {
+			page.NextCursor = page.Keys[len(page.Keys)-1].Key
+			break
+		}
+		page.Keys = append(page.Keys, delivery.listing(key))
+	}
+
+	return page
+}
@@ -1,9 +1,12 @@
 package keyValue
 
 import (
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 	"github.com/denwong47/pigeon-hole/pkg/users"
 )
@@ -11,109 +14,516 @@ import (
 // KeyValueEntry is a single entry in the key-value store.
 //
 // This is an internal struct that is not exposed to the API; it contains mutex locks
-// to ensure that the data is accessed safely.
+// to ensure that the data is accessed safely. The `Delivery` only contains the metadata
+// of the object; its value is kept by the `Backend` of the cache. The metadata of the previous
+// versions of the object, oldest first, is kept in `history`, while their values are also kept
+// by the `Backend`.
 type KeyValueEntry struct {
 	Delivery KeyValueDelivery
+	history  []KeyValueVersion
 	lock     *sync.RWMutex
 }
 
+// Create a new entry holding the metadata of the object.
+func newEntry(delivery KeyValueDelivery) *KeyValueEntry {
+	delivery.Value = nil
+
+	return &KeyValueEntry{
+		Delivery: delivery,
+		lock:     &sync.RWMutex{},
+	}
+}
+
+// Get a copy of the metadata of the entry.
+func (e *KeyValueEntry) metadata() KeyValueDelivery {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.Delivery
+}
+
 // Lock the cache for writing, and perform the specified operation.
+//
+// The version and the `UpdatedAt` timestamp of the object are updated if the operation
+// succeeds, and the previous version is kept in the history of the entry.
 func (kvc *KeyValueCache) LockAndDo(key string, operation func(*KeyValueDelivery) error) error {
 	// Lock the whole cache for reading in case the key got deleted between the check
 	// and the lock.
 	kvc.lock.RLock()
 	defer kvc.lock.RUnlock()
 
-	if entry, ok := kvc.Contents[key]; !ok {
+	if entry, ok := kvc.lookup(key); !ok {
 		return errorMessages.ErrKeyNotFound
 	} else {
-		// Since these locks are passed by reference, locking this entry will lock
-		// the entry in the cache.
 		entry.lock.Lock()
 		defer entry.lock.Unlock()
 
-		// Pass the entry by reference, and perform the operation
-		if err := operation(&(&entry).Delivery); err != nil {
+		// Operate on a copy of the object, so that nothing is changed if the operation fails.
+		delivery, err := kvc.load(key, entry)
+		if err != nil {
 			return err
+		} else if delivery.IsExpired() {
+			// The object could have expired while we were waiting for the lock.
+			return errorMessages.ErrKeyNotFound
 		}
 
-		// Since we may have reassigned some fields in `entry`, which would NOT be
-		// reflected in the cache, we need to reassign the entry back to the cache.
-		kvc.Contents[key] = entry
+		previous := delivery
+		if err := operation(&delivery); err != nil {
+			return err
+		}
+		delivery.Version++
+		delivery.Timestamps.UpdatedAt = time.Now().UTC()
+
+		// The previous version has to be stored before its value is replaced.
+		version, err := kvc.remember(key, previous, delivery.Value)
+		if err != nil {
+			return err
+		}
+		if err := kvc.commit(key, entry, delivery, EventUpdated); err != nil {
+			kvc.forget(key, []KeyValueVersion{version})
+			return err
+		}
+		kvc.keep(key, entry, version)
 
 		return nil
 	}
 }
 
+// Find the unexpired entry for the key; the caller must hold the cache lock.
+func (kvc *KeyValueCache) lookup(key string) (*KeyValueEntry, bool) {
+	if entry, ok := kvc.entries[key]; !ok {
+		return nil, false
+	} else if delivery := entry.metadata(); delivery.IsExpired() {
+		return nil, false
+	} else {
+		return entry, true
+	}
+}
+
+// Get a copy of the object of the entry, including its value from the backend; the caller
+// must hold the entry lock.
+func (kvc *KeyValueCache) load(key string, entry *KeyValueEntry) (KeyValueDelivery, error) {
+	delivery := entry.Delivery
+
+	value, err := kvc.backend.Read(key)
+	if err != nil {
+		return KeyValueDelivery{}, err
+	}
+	delivery.Value = value
+
+	return delivery, nil
+}
+
+// Record the object in the journal and the backend, then update the metadata of the entry
+// and publish the event; the caller must hold the entry lock, or the cache lock for writing.
+//
+// Nothing is changed if the object cannot be recorded.
+func (kvc *KeyValueCache) commit(key string, entry *KeyValueEntry, delivery KeyValueDelivery, kind EventKind) error {
+	delivery.Size = len(delivery.Value)
+
+	if err := kvc.journal.Append(JournalRecord{Operation: JournalPut, Key: key, Delivery: &delivery}); err != nil {
+		return err
+	}
+	if err := kvc.backend.Write(key, delivery); err != nil {
+		return err
+	}
+
+	delivery.Value = nil
+	entry.Delivery = delivery
+	kvc.changes.notify(key)
+	kvc.events.publish(kind, key, delivery)
+
+	return nil
+}
+
+// Remove the object from the journal, the backend and the cache along with its history, then
+// publish the event with the final metadata of the object; the caller must hold the cache lock
+// for writing.
+func (kvc *KeyValueCache) remove(key string, delivery KeyValueDelivery, kind EventKind) error {
+	if err := kvc.journal.Append(JournalRecord{Operation: JournalDelete, Key: key}); err != nil {
+		return err
+	}
+	if err := kvc.discard(key); err != nil {
+		return err
+	}
+	kvc.changes.notify(key)
+	kvc.events.publish(kind, key, delivery)
+
+	return nil
+}
+
+// Remove the object and its history from the backend and the cache without recording it in the
+// journal; the caller must hold the cache lock for writing.
+func (kvc *KeyValueCache) discard(key string) error {
+	if err := kvc.backend.Remove(key); err != nil {
+		return err
+	}
+
+	if entry, ok := kvc.entries[key]; ok {
+		kvc.forget(key, entry.history)
+	}
+	delete(kvc.entries, key)
+
+	return nil
+}
+
+// Insert the object into the backend and the cache without recording it in the journal; the
+// caller must hold the cache lock for writing.
+//
+// This is used to restore objects that had already been recorded elsewhere. If the key
+// already exists with a different version, e.g. when replaying a journal, the existing
+// object is kept in the history of the entry.
+func (kvc *KeyValueCache) restore(key string, delivery KeyValueDelivery) error {
+	delivery.Size = len(delivery.Value)
+
+	entry := newEntry(delivery)
+	var version *KeyValueVersion
+	if existing, ok := kvc.entries[key]; ok {
+		entry.history = existing.history
+		if existing.Delivery.Version != delivery.Version {
+			if previous, err := kvc.load(key, existing); err == nil {
+				if remembered, err := kvc.remember(key, previous, delivery.Value); err == nil {
+					version = &remembered
+				}
+			}
+		}
+	}
+
+	if err := kvc.backend.Write(key, delivery); err != nil {
+		return err
+	}
+
+	if version != nil {
+		kvc.keep(key, entry, *version)
+	}
+	kvc.entries[key] = entry
+
+	return nil
+}
+
 // KeyValueTimestamps is a struct that contains the timestamps associated with a key-value object.
 //
 // Not all of them are required; the `CreatedAt` field is always required, but the `DeliveredAt` field
-// is only required when the object is retrieved.
+// is only required when the object is retrieved. `ExpiresAt` is left as zero for objects that never
+// expire.
+//
+// `AccessedAt` is only kept in memory when the object is read, and is persisted along with the next
+// modification of the object or snapshot of the cache.
 type KeyValueTimestamps struct {
 	CreatedAt   time.Time `json:"createdAt,omitempty" doc:"The time this object was created."`
+	UpdatedAt   time.Time `json:"updatedAt,omitempty" doc:"The time this object was last modified."`
+	AccessedAt  time.Time `json:"accessedAt,omitempty" doc:"The time the value of this object was last read."`
 	DeliveredAt time.Time `json:"deliveredAt,omitempty" doc:"The time this object was retrieved."`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty" doc:"The time this object will expire; zero if it never expires."`
 }
 
 // KeyValueOwnership is a struct that contains the ownership information of a key-value object.
+//
+// If `Recipients` is not empty, the object is addressed to those users, who can read and collect
+// it regardless of their privileges; see `canSelect`.
+//
+// The owner is identified by `Uuid` alone; `Email` and `Name` are copies kept for display, and
+// for resolving the owners of objects persisted before `Uuid` was recorded; see `ResolveOwners`.
 type KeyValueOwnership struct {
-	Email *string `json:"email" doc:"The owner of this object."`
-	Name  *string `json:"name,omitempty" doc:"The name of the owner of this object."`
+	Uuid           *uuid.UUID      `json:"uuid,omitempty" doc:"The unique identifier of the owner of this object."`
+	Email          *string         `json:"email" doc:"The owner of this object."`
+	Name           *string         `json:"name,omitempty" doc:"The name of the owner of this object."`
+	Recipients     []string        `json:"recipients,omitempty" doc:"The emails of the users this object is addressed to."`
+	ACL            []KeyValueGrant `json:"acl,omitempty" doc:"The other users this object is shared with, and what they can do with it."`
+	Group          *KeyValueGroup  `json:"group,omitempty" doc:"The group this object is owned by, in addition to its owner; all its members are treated as owners."`
+	LastModifiedBy string          `json:"lastModifiedBy,omitempty" doc:"The email of the user who last created or modified this object."`
 }
 
+// KeyValueGroup is a group of users that owns an object; see `auth.Group`.
+type KeyValueGroup struct {
+	Uuid uuid.UUID `json:"uuid" doc:"The unique identifier of the group."`
+	Name string    `json:"name" doc:"The name of the group."`
+}
+
+// Create the ownership of an object by the user, copying their details.
+func ownedBy(owner *users.User) KeyValueOwnership {
+	id, email, name := owner.Uuid, owner.Email, owner.Name
+
+	return KeyValueOwnership{
+		Uuid:  &id,
+		Email: &email,
+		Name:  &name,
+	}
+}
+
+// DeliveryMode governs what happens to an object after it is retrieved.
+type DeliveryMode string
+
+const (
+	// The object is retained after retrieval, until it is deleted or expired.
+	DeliveryModePersistent DeliveryMode = "persistent"
+	// The object is removed upon its first successful retrieval.
+	DeliveryModeOnce DeliveryMode = "once"
+)
+
 // KeyValueDelivery is the response object for the delivery endpoint.
 type KeyValueDelivery struct {
 	Value      []byte             `json:"value" doc:"The byte content of the stored object in base64 encoding."`
+	Mode       DeliveryMode       `json:"mode,omitempty" enum:"persistent,once" doc:"The delivery mode of this object; 'once' objects are removed upon first retrieval."`
+	Size       int                `json:"size" doc:"The size of the value in bytes."`
+	Version    uint64             `json:"version" doc:"The version of this object, incremented with every modification."`
 	Timestamps KeyValueTimestamps `json:"timestamps" doc:"The timestamps associated with this object."`
 	Ownership  KeyValueOwnership  `json:"ownedBy"`
 }
 
+// Returns `true` if the object should be removed upon its first retrieval.
+func (d *KeyValueDelivery) IsReadOnce() bool {
+	return d.Mode == DeliveryModeOnce
+}
+
+// KeyValueOptions contains the optional settings that can be specified when a value is inserted or
+// updated.
+type KeyValueOptions struct {
+	// The time-to-live of the value; if zero, the value will not expire on insert, and the existing
+	// expiry will be retained on update.
+	TTL time.Duration
+	// The delivery mode of the value; if empty, the value will be persistent on insert, and the
+	// existing mode will be retained on update.
+	Mode DeliveryMode
+	// The emails of the users the value is addressed to; if empty, the value will not be
+	// addressed on insert, and the existing recipients will be retained on update.
+	Recipients []string
+	// The preconditions that the existing value has to satisfy.
+	Conditions KeyValueConditions
+	// The group that owns the value along with its owner, who has to be a member; this is only
+	// used on insert.
+	Group *KeyValueGroup
+}
+
 // KeyValueCache is a simple key-value store that can be used to store and retrieve data.
 //
 // The `sync.RWMutex` in this struct is used to ensure key creation and deletion is thread-safe;
 // for getting and setting existing values, use the `lock` field in `KeyValueEntry` instead.
+//
+// The metadata of all objects is kept in memory, while their values are kept by the `Backend`.
+// If a `Journal` is attached, all mutations are recorded in it before they are committed.
+// Callers waiting for a key to change, and subscribers to its events, are notified once the
+// change is committed.
 type KeyValueCache struct {
-	Contents map[string]KeyValueEntry
-	lock     *sync.RWMutex
+	entries map[string]*KeyValueEntry
+	lock    *sync.RWMutex
+	backend Backend
+	journal *Journal
+	changes *notifier
+	events  *EventBus
+
+	historyDepth int
 }
 
-// New creates a new key-value cache with empty contents.
+// New creates a new in-memory key-value cache with empty contents.
 func NewCache() KeyValueCache {
 	return KeyValueCache{
-		Contents: make(map[string]KeyValueEntry),
-		lock:     &sync.RWMutex{},
+		entries: make(map[string]*KeyValueEntry),
+		lock:    &sync.RWMutex{},
+		backend: NewMemoryBackend(),
+		changes: newNotifier(),
+		events:  NewEventBus(DefaultEventBufferSize, OverflowDisconnect),
+
+		historyDepth: DefaultHistoryDepth,
 	}
 }
 
+// Create a key-value cache on top of the backend, restoring all the unexpired objects
+// already in it.
+func NewCacheWithBackend(backend Backend) (KeyValueCache, error) {
+	deliveries, err := backend.Restore()
+	if err != nil {
+		return KeyValueCache{}, err
+	}
+
+	kvc := KeyValueCache{
+		entries: make(map[string]*KeyValueEntry, len(deliveries)),
+		lock:    &sync.RWMutex{},
+		backend: backend,
+		changes: newNotifier(),
+		events:  NewEventBus(DefaultEventBufferSize, OverflowDisconnect),
+
+		historyDepth: DefaultHistoryDepth,
+	}
+
+	versions, err := backend.RestoreVersions()
+	if err != nil {
+		return KeyValueCache{}, err
+	}
+
+	for key, delivery := range deliveries {
+		if delivery.IsExpired() {
+			backend.Remove(key)
+		} else {
+			kvc.entries[key] = newEntry(delivery)
+		}
+	}
+
+	// Versions of removed objects, or of objects since replaced with newer versions, are left
+	// behind if the service stopped before they could be removed.
+	for key, history := range versions {
+		entry, ok := kvc.entries[key]
+		if !ok {
+			kvc.forget(key, history)
+			continue
+		}
+
+		stale := slices.IndexFunc(history, func(version KeyValueVersion) bool {
+			return version.Delivery.Version >= entry.Delivery.Version
+		})
+		if stale >= 0 {
+			kvc.forget(key, history[stale:])
+			history = history[:stale]
+		}
+		entry.history = history
+	}
+
+	return kvc, nil
+}
+
 // Fetch an object from the cache.
+//
+// Expired objects that had not yet been swept are treated as if they do not exist.
 func (kvc *KeyValueCache) Get(key string) (KeyValueDelivery, error) {
-	if found, ok := kvc.Contents[key]; !ok {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	if entry, ok := kvc.lookup(key); !ok {
 		return KeyValueDelivery{}, errorMessages.ErrKeyNotFound
 	} else {
-		return found.Delivery, nil
+		entry.lock.RLock()
+		defer entry.lock.RUnlock()
+
+		return kvc.load(key, entry)
 	}
 }
 
 // Fetch a value from the cache.
+//
+// If the object is in `DeliveryModeOnce`, it will be removed from the cache upon retrieval; it is
+// guaranteed that only one caller will ever receive it.
 func (kvc *KeyValueCache) GetValue(key string, user *users.User) (KeyValueDelivery, error) {
+	return kvc.GetValueWithConditions(key, user, KeyValueConditions{})
+}
+
+// Fetch a value from the cache if it satisfies the conditions.
+//
+// An object in `DeliveryModeOnce` is not removed if the conditions are not satisfied.
+func (kvc *KeyValueCache) GetValueWithConditions(
+	key string,
+	user *users.User,
+	conditions KeyValueConditions,
+) (KeyValueDelivery, error) {
 	if delivery, err := kvc.Get(key); err == nil {
-		if user.CanSelect(delivery.Ownership.Email == &user.Email) {
-			return delivery, nil
-		} else {
+		if !delivery.canSelect(key, user) {
 			return KeyValueDelivery{}, errorMessages.ErrNotPermitted
+		} else if err := conditions.check(&delivery); err != nil {
+			return delivery, err
+		} else if delivery.IsReadOnce() {
+			return kvc.collect(key, user, conditions)
+		} else {
+			delivery.Timestamps.AccessedAt = kvc.touch(key)
+			return delivery, nil
 		}
 	} else {
 		return KeyValueDelivery{}, err
 	}
 }
 
+// Retrieve and remove an object from the cache in a single operation, stamping its
+// `DeliveredAt` timestamp.
+//
+// The whole cache is locked for writing, so that concurrent callers cannot both collect
+// the same object.
+func (kvc *KeyValueCache) collect(key string, user *users.User, conditions KeyValueConditions) (KeyValueDelivery, error) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	// Someone else could have collected the object since we last checked.
+	entry, ok := kvc.lookup(key)
+	if !ok {
+		return KeyValueDelivery{}, errorMessages.ErrKeyNotFound
+	}
+
+	delivery, err := kvc.load(key, entry)
+	if err != nil {
+		return KeyValueDelivery{}, err
+	} else if !delivery.canSelect(key, user) {
+		return KeyValueDelivery{}, errorMessages.ErrNotPermitted
+	} else if err := conditions.check(&delivery); err != nil {
+		return delivery, err
+	}
+
+	delivery.Timestamps.DeliveredAt = time.Now().UTC()
+	delivery.Timestamps.AccessedAt = delivery.Timestamps.DeliveredAt
+	if err := kvc.remove(key, delivery, EventDelivered); err != nil {
+		return KeyValueDelivery{}, err
+	}
+
+	return delivery, nil
+}
+
+// Stamp the `AccessedAt` timestamp of an object, returning the time stamped.
+//
+// This only changes the metadata kept in memory; the version of the object is unchanged, and
+// nothing is written to the journal or the backend.
+func (kvc *KeyValueCache) touch(key string) time.Time {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	now := time.Now().UTC()
+	if entry, ok := kvc.lookup(key); ok {
+		entry.lock.Lock()
+		defer entry.lock.Unlock()
+
+		entry.Delivery.Timestamps.AccessedAt = now
+	}
+
+	return now
+}
+
+// Fetch the metadata of an object without its value.
+//
+// Unlike `GetValue`, this never removes objects in `DeliveryModeOnce`, and does not stamp
+// the `AccessedAt` timestamp.
+func (kvc *KeyValueCache) Metadata(key string, user *users.User) (KeyValueDelivery, error) {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	entry, ok := kvc.lookup(key)
+	if !ok {
+		return KeyValueDelivery{}, errorMessages.ErrKeyNotFound
+	}
+
+	delivery := entry.metadata()
+	if !delivery.canSelect(key, user) {
+		return KeyValueDelivery{}, errorMessages.ErrNotPermitted
+	}
+
+	return delivery, nil
+}
+
+// Returns `true` if the cache has an unexpired object under the key.
+func (kvc *KeyValueCache) has(key string) bool {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	_, ok := kvc.lookup(key)
+	return ok
+}
+
 // Get the length of the cache.
 func (kvc *KeyValueCache) Length() int {
-	return len(kvc.Contents)
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	return len(kvc.entries)
 }
 
 // Put an object into the cache.
 //
-// If the key already exists, this will return an error.
+// If the key already exists, this will return an error; an expired key that had not yet been
+// swept will be replaced.
 //
 // This is a low level function that does not check any user permissions;
 // the whole `KeyValueDelivery` object is stored as-is.
@@ -121,14 +531,22 @@ func (kvc *KeyValueCache) Put(key string, value KeyValueDelivery) error {
 	kvc.lock.Lock()
 	defer kvc.lock.Unlock()
 
-	if _, ok := kvc.Contents[key]; ok {
+	if _, ok := kvc.lookup(key); ok {
 		return errorMessages.ErrKeyExists
 	}
+	if value.Version == 0 {
+		value.Version = 1
+	}
 
-	kvc.Contents[key] = KeyValueEntry{
-		Delivery: value,
-		lock:     &sync.RWMutex{},
+	entry := newEntry(value)
+	if err := kvc.commit(key, entry, value, EventCreated); err != nil {
+		return err
 	}
+	// The history of an expired object that had not yet been swept is not carried over.
+	if expired, ok := kvc.entries[key]; ok {
+		kvc.forget(key, expired.history)
+	}
+	kvc.entries[key] = entry
 
 	return nil
 }
@@ -144,19 +562,49 @@ func (kvc *KeyValueCache) PutValue(key string, value []byte, user *users.User) e
 //
 // This requires the user to have the `All.Insert` privilege.
 func (kvc *KeyValueCache) PutValueWithOwner(key string, value []byte, owner *users.User, user *users.User) error {
-	if user.Email == "" || !user.CanInsert(owner.Email == user.Email) {
+	return kvc.PutValueWithOptions(key, value, owner, user, KeyValueOptions{})
+}
+
+// Put a value into the cache using another user as the owner, with the specified options.
+//
+// This requires the user to have the `All.Insert` privilege if the owner is not the user.
+func (kvc *KeyValueCache) PutValueWithOptions(
+	key string,
+	value []byte,
+	owner *users.User,
+	user *users.User,
+	options KeyValueOptions,
+) error {
+	if user.Email == "" || !user.CanInsert(key, owner.Uuid == user.Uuid) {
+		return errorMessages.ErrNotPermitted
+	} else if options.Group != nil && !owner.IsMemberOf(options.Group.Uuid) {
 		return errorMessages.ErrNotPermitted
 	}
+	// There is no existing value to satisfy the conditions.
+	if err := options.Conditions.check(nil); err != nil {
+		return err
+	}
 
+	mode := options.Mode
+	if mode == "" {
+		mode = DeliveryModePersistent
+	}
+
+	ownership := ownedBy(owner)
+	ownership.Recipients = normaliseRecipients(options.Recipients)
+	ownership.LastModifiedBy = user.Email
+	ownership.Group = options.Group
+
+	now := time.Now().UTC()
 	return kvc.Put(key, KeyValueDelivery{
 		Value: value,
+		Mode:  mode,
 		Timestamps: KeyValueTimestamps{
-			CreatedAt: time.Now().UTC(),
-		},
-		Ownership: KeyValueOwnership{
-			Email: &owner.Email,
-			Name:  &owner.Name,
+			CreatedAt: now,
+			UpdatedAt: now,
+			ExpiresAt: options.expiresAt(now),
 		},
+		Ownership: ownership,
 	})
 }
 
@@ -171,7 +619,12 @@ func (kvc *KeyValueCache) Update(
 		key,
 		func(delivery *KeyValueDelivery) error {
 			delivery.Value = value.Value
-			delivery.Timestamps.CreatedAt = time.Now().UTC()
+			if value.Ownership.LastModifiedBy != "" {
+				delivery.Ownership.LastModifiedBy = value.Ownership.LastModifiedBy
+			}
+			if !value.Timestamps.ExpiresAt.IsZero() {
+				delivery.Timestamps.ExpiresAt = value.Timestamps.ExpiresAt
+			}
 			return nil
 		},
 	)
@@ -184,14 +637,41 @@ func (kvc *KeyValueCache) UpdateValue(
 	key string,
 	value []byte,
 	user *users.User,
+) error {
+	return kvc.UpdateValueWithOptions(key, value, user, KeyValueOptions{})
+}
+
+// Update a value in the cache with the specified options.
+//
+// The conditions of the options are checked against the existing object while it is locked.
+// Any of `options.TTL`, `options.Mode` or `options.Recipients` that are specified will replace the
+// existing settings of the object; otherwise the existing settings are retained.
+func (kvc *KeyValueCache) UpdateValueWithOptions(
+	key string,
+	value []byte,
+	user *users.User,
+	options KeyValueOptions,
 ) error {
 	return kvc.LockAndDo(
 		key,
 		func(delivery *KeyValueDelivery) error {
-			owner := delivery.Ownership
-			if owner.Email == nil || user.CanUpdate(owner.Email == &user.Email) {
+			if delivery.canUpdate(key, user) {
+				if err := options.Conditions.check(delivery); err != nil {
+					return err
+				}
+
+				now := time.Now().UTC()
 				delivery.Value = value
-				delivery.Timestamps.CreatedAt = time.Now().UTC()
+				delivery.Ownership.LastModifiedBy = user.Email
+				if options.TTL > 0 {
+					delivery.Timestamps.ExpiresAt = options.expiresAt(now)
+				}
+				if options.Mode != "" {
+					delivery.Mode = options.Mode
+				}
+				if recipients := normaliseRecipients(options.Recipients); recipients != nil {
+					delivery.Ownership.Recipients = recipients
+				}
 
 				return nil
 			} else {
@@ -206,11 +686,12 @@ func (kvc *KeyValueCache) UpdateValue(
 // If the key already exists, this will update the object; otherwise, it will create a new one.
 func (kvc *KeyValueCache) PutOrUpdate(key string, value KeyValueDelivery) error {
 	// Attempt to create the key; if it already exists, update it instead
-	if err := kvc.Put(key, value); err != nil {
+	err := kvc.Put(key, value)
+	if errorMessages.Matches(err, errorMessages.ErrKeyExists) {
 		return kvc.Update(key, value)
 	}
 
-	return nil
+	return err
 }
 
 // Put or update a value in the cache.
@@ -219,12 +700,44 @@ func (kvc *KeyValueCache) PutOrUpdateValue(
 	value []byte,
 	user *users.User,
 ) error {
-	// Attempt to create the key; if it already exists, update it instead
-	if err := kvc.PutValue(key, value, user); err != nil {
-		return kvc.UpdateValue(key, value, user)
+	return kvc.PutOrUpdateValueWithOptions(key, value, user, KeyValueOptions{})
+}
+
+// Put or update a value in the cache with the specified options.
+//
+// The value is updated if the key already exists, so that users who can only update the key need
+// not be able to insert it; any failure to put a new key is returned as is.
+func (kvc *KeyValueCache) PutOrUpdateValueWithOptions(
+	key string,
+	value []byte,
+	user *users.User,
+	options KeyValueOptions,
+) error {
+	// Updating does not change the group, so it must not be used to bypass the membership check
+	if options.Group != nil && !user.IsMemberOf(options.Group.Uuid) {
+		return errorMessages.ErrNotPermitted
 	}
 
-	return nil
+	// A value that has to match an existing one can only be updated
+	if len(options.Conditions.IfMatch) > 0 {
+		err := kvc.UpdateValueWithOptions(key, value, user, options)
+		if errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return errorMessages.ErrPreconditionFailed
+		}
+		return err
+	}
+
+	if kvc.has(key) {
+		return kvc.UpdateValueWithOptions(key, value, user, options)
+	}
+
+	// Attempt to create the key; if it was created in the meantime, update it instead
+	err := kvc.PutValueWithOptions(key, value, user, user, options)
+	if errorMessages.Matches(err, errorMessages.ErrKeyExists) {
+		return kvc.UpdateValueWithOptions(key, value, user, options)
+	}
+
+	return err
 }
 
 // Delete an object from the cache.
@@ -235,34 +748,61 @@ func (kvc *KeyValueCache) Delete(key string) error {
 	kvc.lock.Lock()
 	defer kvc.lock.Unlock()
 
-	if _, ok := kvc.Contents[key]; !ok {
+	entry, ok := kvc.lookup(key)
+	if !ok {
 		return errorMessages.ErrKeyNotFound
 	}
 
-	delete(kvc.Contents, key)
-
-	return nil
+	return kvc.remove(key, entry.Delivery, EventDeleted)
 }
 
 // Delete a value from the cache.
 //
-// This is a high level function that will check if the user has permission to delete the object.
+// This is a high level function that will check if the user has permission to delete the object;
+// recipients of an addressed object are always permitted to collect it this way.
 func (kvc *KeyValueCache) DeleteValue(
 	key string,
 	user *users.User,
 ) (*KeyValueDelivery, error) {
-	if delivery, err := kvc.Get(key); err != nil {
+	return kvc.DeleteValueWithConditions(key, user, KeyValueConditions{})
+}
+
+// Delete a value from the cache if it satisfies the conditions.
+//
+// The whole cache is locked for writing, so that the object cannot change between the checks
+// and its removal.
+func (kvc *KeyValueCache) DeleteValueWithConditions(
+	key string,
+	user *users.User,
+	conditions KeyValueConditions,
+) (*KeyValueDelivery, error) {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	entry, ok := kvc.lookup(key)
+	if !ok {
+		return &KeyValueDelivery{}, errorMessages.ErrKeyNotFound
+	}
+
+	delivery, err := kvc.load(key, entry)
+	if err != nil {
 		return &KeyValueDelivery{}, err
-	} else {
-		owner := delivery.Ownership
-		if owner.Email == nil || user.CanDelete(owner.Email == &user.Email) {
-			if err := kvc.Delete(key); err != nil {
-				return &KeyValueDelivery{}, err
-			} else {
-				return &delivery, nil
-			}
-		} else {
-			return &KeyValueDelivery{}, errorMessages.ErrNotPermitted
-		}
+	} else if !delivery.canDelete(key, user) {
+		return &KeyValueDelivery{}, errorMessages.ErrNotPermitted
+	} else if err := conditions.check(&delivery); err != nil {
+		return &KeyValueDelivery{}, err
+	}
+
+	// A recipient deleting an object addressed to them is collecting it.
+	kind := EventDeleted
+	if delivery.IsAddressedTo(user) {
+		kind = EventDelivered
+		delivery.Timestamps.DeliveredAt = time.Now().UTC()
 	}
+
+	if err := kvc.remove(key, delivery, kind); err != nil {
+		return &KeyValueDelivery{}, err
+	}
+
+	return &delivery, nil
 }
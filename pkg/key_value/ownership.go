@@ -0,0 +1,148 @@
+package keyValue
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// Give the object to another owner.
+//
+// This requires the user to be its owner, or to have the `All.Update` privilege. The recipients,
+// the access control list and the settings of the object are retained.
+func (kvc *KeyValueCache) TransferValue(
+	key string,
+	owner *users.User,
+	user *users.User,
+	conditions KeyValueConditions,
+) error {
+	return kvc.LockAndDo(
+		key,
+		func(delivery *KeyValueDelivery) error {
+			if !delivery.canShare(key, user) {
+				return errorMessages.ErrNotPermitted
+			} else if err := conditions.check(delivery); err != nil {
+				return err
+			}
+
+			delivery.transferTo(owner, user.Email)
+			return nil
+		},
+	)
+}
+
+// Give all the objects owned by the user with the UUID to another owner, returning the number
+// of objects transferred.
+//
+// Objects are matched by the UUID of their owner, so that the objects of an earlier user with
+// the same email are not included. Users can only transfer their own objects, unless they have
+// the `All.Update` privilege.
+func (kvc *KeyValueCache) TransferValues(from uuid.UUID, owner *users.User, user *users.User) (int, error) {
+	if from != user.Uuid && !user.CanUpdateAny() {
+		return 0, errorMessages.ErrNotPermitted
+	}
+
+	return kvc.transfer(ownedByUuid(from), owner, user.Email, func(key string, delivery *KeyValueDelivery) bool {
+		return delivery.canShare(key, user)
+	})
+}
+
+// Give all the objects owned by users with the email to another owner, returning the number of
+// objects transferred; this recovers the objects of users who have been removed.
+//
+// Unlike `TransferValues`, this matches every earlier user with the email, so it should only be
+// used if no existing user has the email. This requires the `All.Update` privilege.
+func (kvc *KeyValueCache) TransferValuesByEmail(from string, owner *users.User, user *users.User) (int, error) {
+	if !user.CanUpdateAny() {
+		return 0, errorMessages.ErrNotPermitted
+	}
+
+	return kvc.transfer(ownedByEmail(from), owner, user.Email, func(key string, delivery *KeyValueDelivery) bool {
+		return delivery.canShare(key, user)
+	})
+}
+
+// Give all the objects owned by the user with the UUID to another owner, returning the number
+// of objects transferred.
+//
+// This is a low level function that does not check any user permissions; use
+// `TransferValues` instead.
+func (kvc *KeyValueCache) Transfer(from uuid.UUID, owner *users.User) (int, error) {
+	return kvc.transfer(ownedByUuid(from), owner, "", func(string, *KeyValueDelivery) bool {
+		return true
+	})
+}
+
+// Transfer all the permitted objects that match the previous owner, one at a time.
+func (kvc *KeyValueCache) transfer(
+	from func(*KeyValueDelivery) bool,
+	owner *users.User,
+	modifiedBy string,
+	permitted func(string, *KeyValueDelivery) bool,
+) (int, error) {
+	kvc.lock.RLock()
+	keys := make([]string, 0)
+	for key, entry := range kvc.entries {
+		if delivery := entry.metadata(); from(&delivery) {
+			keys = append(keys, key)
+		}
+	}
+	kvc.lock.RUnlock()
+
+	transferred := 0
+	for _, key := range keys {
+		err := kvc.LockAndDo(
+			key,
+			func(delivery *KeyValueDelivery) error {
+				// The object could have changed since we listed the keys.
+				if !from(delivery) || !permitted(key, delivery) {
+					return errorMessages.ErrNotPermitted
+				}
+
+				delivery.transferTo(owner, modifiedBy)
+				return nil
+			},
+		)
+
+		if err == nil {
+			transferred++
+		} else if !errorMessages.Matches(err, errorMessages.ErrNotPermitted) && !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) {
+			return transferred, err
+		}
+	}
+
+	return transferred, nil
+}
+
+// Match the objects whose owner has the UUID, whether or not they still exist.
+func ownedByUuid(id uuid.UUID) func(*KeyValueDelivery) bool {
+	return func(d *KeyValueDelivery) bool {
+		return d.Ownership.Uuid != nil && *d.Ownership.Uuid == id
+	}
+}
+
+// Match the objects whose owner has the email, whether or not they still exist.
+func ownedByEmail(email string) func(*KeyValueDelivery) bool {
+	return func(d *KeyValueDelivery) bool {
+		return d.Ownership.Email != nil && strings.EqualFold(*d.Ownership.Email, email)
+	}
+}
+
+// Replace the owner of the object, keeping its recipients, access control list and group.
+func (d *KeyValueDelivery) transferTo(owner *users.User, modifiedBy string) {
+	ownership := ownedBy(owner)
+	ownership.Recipients = d.Ownership.Recipients
+	ownership.ACL = d.Ownership.ACL
+	ownership.Group = d.Ownership.Group
+	ownership.LastModifiedBy = d.Ownership.LastModifiedBy
+	if modifiedBy != "" {
+		ownership.LastModifiedBy = modifiedBy
+	}
+
+	d.Ownership = ownership
+	// The new owner does not need a grant any more.
+	d.Ownership.ACL = d.normaliseACL(d.Ownership.ACL)
+}
@@ -0,0 +1,106 @@
+package keyValue
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// Returns `true` if the user is the owner of the object, or a member of the group that owns it.
+//
+// Owners are identified by their UUID, so that ownership holds for any copy of the user, e.g.
+// after the user list is reloaded.
+func (d *KeyValueDelivery) isOwnedBy(user *users.User) bool {
+	if d.Ownership.Group != nil && user.IsMemberOf(d.Ownership.Group.Uuid) {
+		return true
+	}
+	return d.Ownership.Uuid != nil && *d.Ownership.Uuid == user.Uuid
+}
+
+// Returns `true` if the object has an owner, even if the owner could not be resolved.
+func (d *KeyValueDelivery) isOwned() bool {
+	return d.Ownership.Uuid != nil || d.Ownership.Email != nil
+}
+
+// Returns `true` if the object is addressed to specific recipients.
+func (d *KeyValueDelivery) IsAddressed() bool {
+	return len(d.Ownership.Recipients) > 0
+}
+
+// Returns `true` if the user is one of the recipients of the object.
+func (d *KeyValueDelivery) IsAddressedTo(user *users.User) bool {
+	return user.Email != "" && slices.Contains(d.Ownership.Recipients, strings.ToLower(user.Email))
+}
+
+// Returns `true` if the user is allowed to read the object at the key.
+//
+// Recipients can always read an object addressed to them, even outside the scopes of their
+// privileges, unless they authenticated with an API key that does not let them read objects
+// of their own at the key; other users are subject to their privileges as usual.
+func (d *KeyValueDelivery) canSelect(key string, user *users.User) bool {
+	if d.IsAddressedTo(user) && user.RestrictedOwned(key).Select {
+		return true
+	}
+
+	grant, _ := d.grantFor(user)
+	return user.CanSelect(key, d.isOwnedBy(user) || grant.Select)
+}
+
+// Returns `true` if the user is allowed to update the object at the key.
+//
+// Objects without an owner can be updated by anyone, as long as the key is within the scopes
+// of their privileges.
+func (d *KeyValueDelivery) canUpdate(key string, user *users.User) bool {
+	if !d.isOwned() {
+		return user.InScope(key)
+	}
+
+	grant, _ := d.grantFor(user)
+	return user.CanUpdate(key, d.isOwnedBy(user) || grant.Update)
+}
+
+// Returns `true` if the user is allowed to delete the object at the key.
+//
+// Recipients can always delete, i.e. collect, an object addressed to them, even outside the
+// scopes of their privileges, unless they authenticated with an API key that does not let them
+// delete objects of their own at the key.
+func (d *KeyValueDelivery) canDelete(key string, user *users.User) bool {
+	grant, _ := d.grantFor(user)
+	if d.IsAddressedTo(user) && user.RestrictedOwned(key).Delete {
+		return true
+	} else if !d.isOwned() {
+		return user.InScope(key)
+	}
+
+	return user.CanDelete(key, d.isOwnedBy(user) || grant.Delete)
+}
+
+// Returns `true` if the user is allowed to change who owns and who can access the object at
+// the key.
+//
+// Unlike `canUpdate`, this ignores the access control list, so that users the object is shared
+// with cannot share it further or take it over.
+func (d *KeyValueDelivery) canShare(key string, user *users.User) bool {
+	if !d.isOwned() {
+		return user.InScope(key)
+	}
+
+	return user.CanUpdate(key, d.isOwnedBy(user))
+}
+
+// Normalise a list of recipient emails, removing duplicates and empty entries.
+func normaliseRecipients(recipients []string) []string {
+	normalised := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		recipient = strings.ToLower(strings.TrimSpace(recipient))
+		if recipient != "" && !slices.Contains(normalised, recipient) {
+			normalised = append(normalised, recipient)
+		}
+	}
+
+	if len(normalised) == 0 {
+		return nil
+	}
+	return normalised
+}
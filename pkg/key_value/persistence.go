@@ -0,0 +1,202 @@
+package keyValue
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// KeyValueSnapshot is the serialized form of a `KeyValueCache`.
+type KeyValueSnapshot struct {
+	Timestamp time.Time                     `json:"timestamp" doc:"The time this snapshot was taken."`
+	Contents  map[string]KeyValueDelivery   `json:"contents" doc:"The objects in the cache, by key."`
+	History   map[string][]KeyValueDelivery `json:"history,omitempty" doc:"The previous versions of the objects in the cache, oldest first, by key."`
+}
+
+// Take a snapshot of all the unexpired objects in the cache.
+func (kvc *KeyValueCache) Snapshot() KeyValueSnapshot {
+	kvc.lock.RLock()
+	defer kvc.lock.RUnlock()
+
+	return kvc.snapshot()
+}
+
+// Take a snapshot of the cache without locking it; the caller must hold the cache lock.
+func (kvc *KeyValueCache) snapshot() KeyValueSnapshot {
+	snapshot := KeyValueSnapshot{
+		Timestamp: time.Now().UTC(),
+		Contents:  make(map[string]KeyValueDelivery, len(kvc.entries)),
+		History:   make(map[string][]KeyValueDelivery),
+	}
+
+	for key, entry := range kvc.entries {
+		// Individual entries could be updated while we hold the read lock on the cache.
+		entry.lock.RLock()
+		if !entry.Delivery.IsExpired() {
+			if delivery, err := kvc.load(key, entry); err != nil {
+				log.Printf("Failed to read key '%s' for snapshot: %s\n", key, err)
+			} else {
+				snapshot.Contents[key] = delivery
+				if history := kvc.snapshotHistory(key, entry); len(history) > 0 {
+					snapshot.History[key] = history
+				}
+			}
+		}
+		entry.lock.RUnlock()
+	}
+
+	return snapshot
+}
+
+// Read the previous versions of the object along with their values, oldest first; the caller
+// must hold the entry lock.
+//
+// Versions whose values cannot be read are left out with a warning.
+func (kvc *KeyValueCache) snapshotHistory(key string, entry *KeyValueEntry) []KeyValueDelivery {
+	history := make([]KeyValueDelivery, 0, len(entry.history))
+	for i, version := range entry.history {
+		delivery := version.Delivery
+		if value, err := kvc.versionValue(key, entry, i); err != nil {
+			log.Printf("Failed to read version %d of key '%s' for snapshot: %s\n", delivery.Version, key, err)
+		} else {
+			delivery.Value = value
+			history = append(history, delivery)
+		}
+	}
+
+	return history
+}
+
+// Restore an in-memory cache from a snapshot.
+//
+// Objects persisted before their owners' UUIDs were recorded are not owned by anyone until
+// `ResolveOwners` is called.
+func FromSnapshot(snapshot KeyValueSnapshot) KeyValueCache {
+	kvc := NewCache()
+
+	for key, delivery := range snapshot.Contents {
+		if !delivery.IsExpired() {
+			// Writing to a `MemoryBackend` cannot fail.
+			kvc.restore(key, delivery)
+			kvc.restoreHistory(key, kvc.entries[key], snapshot.History[key])
+		}
+	}
+
+	return kvc
+}
+
+// ExportTo writes a snapshot of the cache to a file.
+//
+// The snapshot is written to a temporary file first, then renamed over the destination, so
+// that the destination file is never left partially written.
+func (kvc *KeyValueCache) ExportTo(path string) error {
+	buffer, err := json.Marshal(kvc.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Writing %d bytes of key-value data to %s...\n", len(buffer), path)
+	return writeFileAtomically(path, buffer)
+}
+
+// ImportFrom reads a cache from a snapshot file.
+func ImportFrom(path string) (KeyValueCache, error) {
+	buffer, err := os.ReadFile(path)
+
+	if err != nil {
+		return KeyValueCache{}, errorMessages.ErrDataFileNotFound
+	}
+
+	var snapshot KeyValueSnapshot
+	if err := json.Unmarshal(buffer, &snapshot); err != nil {
+		return KeyValueCache{}, err
+	}
+
+	return FromSnapshot(snapshot), nil
+}
+
+// ImportFromOrNew reads a cache from a snapshot file, or creates a new one if the file does not exist.
+func ImportFromOrNew(path string) (KeyValueCache, error) {
+	if kvc, err := ImportFrom(path); err != nil {
+		if errorMessages.Matches(err, errorMessages.ErrDataFileNotFound) {
+			// The file does not exist, create a new cache
+			return NewCache(), nil
+		} else {
+			// Something else went wrong, return the error
+			return KeyValueCache{}, err
+		}
+	} else {
+		// The file was read successfully
+		return kvc, nil
+	}
+}
+
+// Start a background goroutine that writes a snapshot of the cache to a file at the
+// specified interval.
+//
+// Call the returned function to stop the goroutine.
+func (kvc *KeyValueCache) StartSnapshots(path string, interval time.Duration) func() {
+	return every(interval, func() {
+		if err := kvc.ExportTo(path); err != nil {
+			log.Printf("Failed to write snapshot to %s: %s\n", path, err)
+		}
+	})
+}
+
+// Resolve the owners of all objects against the users returned by `lookup`, by email.
+//
+// Objects without the UUID of their owner, i.e. persisted by older versions, are assigned the
+// UUID of the user with the same email. Returns the number of objects whose owners could not
+// be found, including those whose owner had been replaced by another user with the same email.
+func (kvc *KeyValueCache) ResolveOwners(lookup func(email string) (*users.User, error)) int {
+	kvc.lock.Lock()
+	defer kvc.lock.Unlock()
+
+	orphaned := 0
+	for _, entry := range kvc.entries {
+		ownership := &entry.Delivery.Ownership
+		if ownership.Email == nil {
+			continue
+		}
+
+		if user, err := lookup(*ownership.Email); err != nil {
+			orphaned++
+		} else if ownership.Uuid == nil {
+			id := user.Uuid
+			ownership.Uuid = &id
+		} else if *ownership.Uuid != user.Uuid {
+			orphaned++
+		}
+	}
+
+	return orphaned
+}
+
+// Write the contents to a temporary file in the same directory, then rename it over `path`.
+func writeFileAtomically(path string, buffer []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// This is a no-op once the file had been renamed.
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(buffer); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,59 @@
+package keyValue
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// Store is the interface through which the API accesses the key-value store.
+//
+// All of these operations check the permissions of the user against the ownership of the
+// objects; see `KeyValueCache` for the implementation, which can be backed by any `Backend`.
+type Store interface {
+	// Fetch a value if it satisfies the conditions, removing it if it is in `DeliveryModeOnce`.
+	GetValueWithConditions(key string, user *users.User, conditions KeyValueConditions) (KeyValueDelivery, error)
+	// Fetch a value, waiting until it is created or changed, or until the context is done.
+	WaitForValue(ctx context.Context, key string, user *users.User, conditions KeyValueConditions) (KeyValueDelivery, error)
+	// Fetch the metadata of a value without the value itself.
+	Metadata(key string, user *users.User) (KeyValueDelivery, error)
+	// Put a new value, using another user as the owner.
+	PutValueWithOptions(key string, value []byte, owner *users.User, user *users.User, options KeyValueOptions) error
+	// Update an existing value.
+	UpdateValueWithOptions(key string, value []byte, user *users.User, options KeyValueOptions) error
+	// Put a new value with the user as the owner, or update it if it already exists.
+	PutOrUpdateValueWithOptions(key string, value []byte, user *users.User, options KeyValueOptions) error
+	// Delete a value if it satisfies the conditions, returning what was deleted.
+	DeleteValueWithConditions(key string, user *users.User, conditions KeyValueConditions) (*KeyValueDelivery, error)
+	// List the versions of a value, newest first.
+	Versions(key string, user *users.User) ([]KeyValueListing, error)
+	// Fetch a version of a value.
+	GetVersion(key string, version uint64, user *users.User) (KeyValueDelivery, error)
+	// Restore the value of a previous version as a new version.
+	RestoreVersion(key string, version uint64, user *users.User, conditions KeyValueConditions) error
+	// Replace the access control list of a value.
+	SetACL(key string, acl []KeyValueGrant, user *users.User, conditions KeyValueConditions) ([]KeyValueGrant, error)
+	// Give a value to another owner.
+	TransferValue(key string, owner *users.User, user *users.User, conditions KeyValueConditions) error
+	// Give all the values owned by the user with the UUID to another owner.
+	TransferValues(from uuid.UUID, owner *users.User, user *users.User) (int, error)
+	// Give all the values owned by removed users with the email to another owner.
+	TransferValuesByEmail(from string, owner *users.User, user *users.User) (int, error)
+	// Give all the values owned by the user with the UUID to another owner, without checking permissions.
+	Transfer(from uuid.UUID, owner *users.User) (int, error)
+	// List all the values addressed to the user.
+	AddressedTo(user *users.User) []KeyValueListing
+	// List the values that the user is allowed to read, a page at a time.
+	List(user *users.User, prefix string, cursor string, limit int) KeyValuePage
+	// Subscribe to the events of the values that the user is allowed to read.
+	Subscribe(user *users.User, prefix string) *Subscription
+	// Subscribe to the events of the values that the user, as looked up at each event, is allowed to read.
+	SubscribeAs(lookup func() (*users.User, error), prefix string) *Subscription
+	// Get the number of values in the store.
+	Length() int
+}
+
+// Make sure that `KeyValueCache` implements `Store`.
+var _ Store = (*KeyValueCache)(nil)
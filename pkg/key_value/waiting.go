@@ -0,0 +1,97 @@
+package keyValue
+
+import (
+	"context"
+	"sync"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// keyWaiters is the channel shared by everyone waiting on a key; it is closed when the key
+// changes.
+type keyWaiters struct {
+	changed chan struct{}
+	count   int
+}
+
+// notifier wakes up the callers waiting for keys to change, without them having to poll.
+type notifier struct {
+	waiters map[string]*keyWaiters
+	lock    sync.Mutex
+}
+
+// Create a new notifier with no one waiting.
+func newNotifier() *notifier {
+	return &notifier{
+		waiters: make(map[string]*keyWaiters),
+	}
+}
+
+// Get a channel that is closed the next time the key changes.
+//
+// Call the returned function once the channel is no longer needed, so that keys that never
+// change do not accumulate channels.
+func (n *notifier) wait(key string) (<-chan struct{}, func()) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	waiters, ok := n.waiters[key]
+	if !ok {
+		waiters = &keyWaiters{changed: make(chan struct{})}
+		n.waiters[key] = waiters
+	}
+	waiters.count++
+
+	return waiters.changed, func() {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+
+		waiters.count--
+		if waiters.count == 0 && n.waiters[key] == waiters {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// Wake up everyone waiting on the key.
+func (n *notifier) notify(key string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if waiters, ok := n.waiters[key]; ok {
+		close(waiters.changed)
+		delete(n.waiters, key)
+	}
+}
+
+// Fetch a value from the cache, waiting until it is created or, if `conditions.IfNoneMatch`
+// is specified, until it no longer matches.
+//
+// The wait ends when `ctx` is done, in which case the outcome of the last attempt is returned,
+// i.e. `ErrKeyNotFound` or `ErrNotModified`. Any other outcome is returned immediately.
+func (kvc *KeyValueCache) WaitForValue(
+	ctx context.Context,
+	key string,
+	user *users.User,
+	conditions KeyValueConditions,
+) (KeyValueDelivery, error) {
+	for {
+		// Start listening before looking, so that no change can be missed in between.
+		changed, release := kvc.changes.wait(key)
+		delivery, err := kvc.GetValueWithConditions(key, user, conditions)
+
+		if !errorMessages.Matches(err, errorMessages.ErrKeyNotFound) && !errorMessages.Matches(err, errorMessages.ErrNotModified) {
+			release()
+			return delivery, err
+		}
+
+		select {
+		case <-changed:
+			release()
+		case <-ctx.Done():
+			release()
+			return delivery, err
+		}
+	}
+}
@@ -2,7 +2,9 @@ package tokens
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 )
@@ -18,3 +20,21 @@ func GenerateToken(length int) (string, error) {
 	}
 	return base64.StdEncoding.EncodeToString(bytes), nil
 }
+
+// HashToken returns the hash a token is kept by.
+//
+// Tokens are random and long enough that they need no salt; the hash only needs to prevent the
+// raw token from being recovered.
+func HashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// The number of characters of the hash of a token shown by `RedactToken`.
+const redactedTokenLength = 8
+
+// RedactToken returns the start of the hash of a token, which tells tokens apart in the logs
+// without revealing them.
+func RedactToken(token string) string {
+	return HashToken(token)[:redactedTokenLength]
+}
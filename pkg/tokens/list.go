@@ -2,36 +2,54 @@ package tokens
 
 import (
 	"log"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
 	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
 	"github.com/denwong47/pigeon-hole/pkg/users"
 )
 
 // Token Manager. This is a singleton that manages the tokens.
+//
+// Tokens are kept by their hash rather than their raw value, so that the tokens can be
+// persisted without leaking them; see `HashToken`.
 type TokenManager struct {
-	tokens     map[string]TokenData
-	lock       sync.RWMutex
-	expiration time.Duration
+	tokens      map[string]TokenData
+	lock        sync.RWMutex
+	expiration  time.Duration
+	maxLifetime time.Duration
 }
 
 type TokenData struct {
-	Token  string
-	User   *users.User
-	Expiry time.Time
+	// The raw token; this is only known when the token is created.
+	Token string
+	// The identifier of the session, which unlike the token can be shown to the user.
+	Id      string
+	User    *users.User
+	Created time.Time
+	Expiry  time.Time
+	// The IP address the session was created from, if known.
+	IP string
 }
 
 // NewTokenManager creates a new TokenManager.
-func NewTokenManager(expiration time.Duration) TokenManager {
+//
+// If `maxLifetime` is positive, the expiry of tokens slides: each use of a token extends it to
+// `expiration` from then, but never beyond `maxLifetime` from when the token was created.
+// Otherwise, tokens expire `expiration` after they were created regardless of their use.
+func NewTokenManager(expiration time.Duration, maxLifetime time.Duration) TokenManager {
 	return TokenManager{
-		tokens:     make(map[string]TokenData, 0),
-		expiration: expiration,
+		tokens:      make(map[string]TokenData, 0),
+		expiration:  expiration,
+		maxLifetime: maxLifetime,
 	}
 }
 
-// CreateToken creates a new token that points to a user.
-func (tm *TokenManager) CreateToken(user *users.User) (*TokenData, error) {
+// CreateToken creates a new token that points to a user, from the IP address if known.
+func (tm *TokenManager) CreateToken(user *users.User, ip string) (*TokenData, error) {
 	token, err := GenerateToken(TokenLength)
 
 	if err != nil {
@@ -41,30 +59,127 @@ func (tm *TokenManager) CreateToken(user *users.User) (*TokenData, error) {
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
 
+	return tm.addToken(token, user, ip), nil
+}
+
+// Add the token for the user; the caller must hold the lock for writing.
+func (tm *TokenManager) addToken(token string, user *users.User, ip string) *TokenData {
+	now := time.Now()
 	tokenData := TokenData{
-		Token:  token,
-		User:   user,
-		Expiry: time.Now().Add(tm.expiration),
+		Id:      uuid.NewString(),
+		User:    user,
+		Created: now,
+		Expiry:  now.Add(tm.expiration),
+		IP:      ip,
 	}
-	tm.tokens[token] = tokenData
+	tm.tokens[HashToken(token)] = tokenData
 
-	return &tokenData, nil
+	// Only the caller gets to know the raw token.
+	tokenData.Token = token
+	return &tokenData
 }
 
 // GetUser retrieves the `TokenData“ from the token.
+//
+// If the expiry of tokens slides, this extends the expiry of the token; the lock is only taken
+// for writing when the expiry actually changes.
 func (tm *TokenManager) GetToken(token string) (*TokenData, error) {
+	hash := HashToken(token)
+
 	tm.lock.RLock()
-	defer tm.lock.RUnlock()
+	data, ok := tm.tokens[hash]
+	tm.lock.RUnlock()
+
+	if !ok {
+		return &TokenData{}, errorMessages.ErrTokenInvalid
+	}
+
+	log.Printf("Token of session %s found for user %s, expiring at %s.\n", data.Id, data.User.Name, data.Expiry)
+	now := time.Now()
+	if data.Expiry.Before(now) {
+		return &TokenData{}, errorMessages.ErrTokenExpired
+	}
 
-	if data, ok := tm.tokens[token]; ok {
-		log.Printf("Token %s found for user %s, expiring at %s.\n", token, data.User.Name, data.Expiry)
-		if data.Expiry.Before(time.Now()) {
-			return &TokenData{}, errorMessages.ErrTokenExpired
+	if tm.maxLifetime > 0 {
+		if expiry := tm.expiryAt(data.Created, now); expiry.After(data.Expiry) {
+			return tm.extendToken(hash, expiry)
 		}
+	}
+	return &data, nil
+}
+
+// Find the expiry of a token of a session created at `created` that is used at `now`, which is
+// never beyond the maximum lifetime of the session.
+func (tm *TokenManager) expiryAt(created time.Time, now time.Time) time.Time {
+	expiry := now.Add(tm.expiration)
+	if limit := created.Add(tm.maxLifetime); tm.maxLifetime > 0 && expiry.After(limit) {
+		expiry = limit
+	}
+	return expiry
+}
+
+// Extend the expiry of the token with the hash, unless it had been extended further or removed
+// since it was looked up.
+func (tm *TokenManager) extendToken(hash string, expiry time.Time) (*TokenData, error) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	data, ok := tm.tokens[hash]
+	if !ok {
+		return &TokenData{}, errorMessages.ErrTokenInvalid
+	}
+	if expiry.After(data.Expiry) {
+		data.Expiry = expiry
+		tm.tokens[hash] = data
+	}
+	return &data, nil
+}
+
+// LookupToken retrieves the `TokenData` from the token without extending its expiry, e.g. to
+// check that a token is still valid while it is not being used.
+func (tm *TokenManager) LookupToken(token string) (*TokenData, error) {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	if data, ok := tm.tokens[HashToken(token)]; !ok {
+		return &TokenData{}, errorMessages.ErrTokenInvalid
+	} else if data.Expiry.Before(time.Now()) {
+		return &TokenData{}, errorMessages.ErrTokenExpired
+	} else {
 		return &data, nil
 	}
+}
+
+// RefreshToken exchanges a token that has not expired for a new one for the same user, from the
+// IP address if known, revoking the old token.
+//
+// The new token continues the same session, keeping its `Id` and `Created` time, so that
+// refreshing cannot extend a session beyond the maximum lifetime.
+func (tm *TokenManager) RefreshToken(token string, ip string) (*TokenData, error) {
+	refreshed, err := GenerateToken(TokenLength)
+	if err != nil {
+		return &TokenData{}, err
+	}
+
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	hash := HashToken(token)
+	data, ok := tm.tokens[hash]
+	if !ok {
+		return &TokenData{}, errorMessages.ErrTokenInvalid
+	} else if data.Expiry.Before(time.Now()) {
+		return &TokenData{}, errorMessages.ErrTokenExpired
+	}
+
+	delete(tm.tokens, hash)
+	data.Expiry = tm.expiryAt(data.Created, time.Now())
+	data.IP = ip
+	tm.tokens[HashToken(refreshed)] = data
 
-	return &TokenData{}, errorMessages.ErrTokenInvalid
+	// Only the caller gets to know the raw token.
+	data.Token = refreshed
+	return &data, nil
 }
 
 // DeleteToken removes the token from the manager.
@@ -72,10 +187,82 @@ func (tm *TokenManager) DeleteToken(token string) error {
 	tm.lock.Lock()
 	defer tm.lock.Unlock()
 
-	if _, ok := tm.tokens[token]; ok {
-		delete(tm.tokens, token)
+	if _, ok := tm.tokens[HashToken(token)]; ok {
+		delete(tm.tokens, HashToken(token))
 		return nil
 	}
 
 	return errorMessages.ErrTokenInvalid
 }
+
+// ReplaceUser points all the tokens of the user, identified by their UUID, to the updated user.
+//
+// Users are never modified in place, as they could be in use by other requests; a modified
+// copy is made instead, which the tokens are then pointed to.
+func (tm *TokenManager) ReplaceUser(user *users.User) int {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	replaced := 0
+	for token, data := range tm.tokens {
+		if data.User.Uuid == user.Uuid {
+			data.User = user
+			tm.tokens[token] = data
+			replaced++
+		}
+	}
+
+	return replaced
+}
+
+// Sessions lists the unexpired tokens of the user, identified by their UUID, oldest first.
+//
+// The raw tokens are not known, so the sessions can only be told apart by their `Id`.
+func (tm *TokenManager) Sessions(user uuid.UUID) []TokenData {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	now := time.Now()
+	sessions := make([]TokenData, 0)
+	for _, data := range tm.tokens {
+		if data.User.Uuid == user && data.Expiry.After(now) {
+			sessions = append(sessions, data)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	return sessions
+}
+
+// DeleteSession removes the token of the user with the session identifier.
+func (tm *TokenManager) DeleteSession(user uuid.UUID, id string) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	for hash, data := range tm.tokens {
+		if data.User.Uuid == user && data.Id == id {
+			delete(tm.tokens, hash)
+			return nil
+		}
+	}
+
+	return errorMessages.ErrSessionNotFound
+}
+
+// DeleteSessions removes all the tokens of the user, returning the number of tokens removed.
+func (tm *TokenManager) DeleteSessions(user uuid.UUID) int {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	deleted := 0
+	for hash, data := range tm.tokens {
+		if data.User.Uuid == user {
+			delete(tm.tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted
+}
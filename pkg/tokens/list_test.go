@@ -0,0 +1,98 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+func TestTokenManagerExpiry(t *testing.T) {
+	user := users.NewUser("Steve", "steve@test.com", users.StandardUser())
+
+	// Without a maximum lifetime, using a token does not extend it.
+	fixed := NewTokenManager(time.Hour, 0)
+	token, _ := fixed.CreateToken(&user, "")
+	if data, _ := fixed.GetToken(token.Token); !data.Expiry.Equal(token.Expiry) {
+		t.Errorf("Expected the expiry not to slide, got %s instead of %s", data.Expiry, token.Expiry)
+	}
+
+	// Using a token extends it, but only up to the maximum lifetime.
+	sliding := NewTokenManager(time.Hour, 90*time.Minute)
+	token, _ = sliding.CreateToken(&user, "")
+	sliding.lock.Lock()
+	data := sliding.tokens[HashToken(token.Token)]
+	data.Created = data.Created.Add(-time.Hour)
+	data.Expiry = data.Expiry.Add(-time.Hour + time.Minute)
+	sliding.tokens[HashToken(token.Token)] = data
+	sliding.lock.Unlock()
+
+	if data, err := sliding.GetToken(token.Token); err != nil {
+		t.Errorf("Expected no error getting the token, got '%s'", err)
+	} else if expected := data.Created.Add(90 * time.Minute); !data.Expiry.Equal(expected) {
+		t.Errorf("Expected the expiry to slide up to %s, got %s", expected, data.Expiry)
+	}
+
+	// Refreshing replaces the token with a new one in the same session.
+	refreshed, err := sliding.RefreshToken(token.Token, "")
+	if err != nil {
+		t.Fatalf("Expected no error refreshing the token, got '%s'", err)
+	}
+	if _, err := sliding.GetToken(token.Token); !errorMessages.Matches(err, errorMessages.ErrTokenInvalid) {
+		t.Errorf(`Expected "ErrTokenInvalid" error getting the old token, got '%s'`, err)
+	}
+	if data, err := sliding.GetToken(refreshed.Token); err != nil || data.User != &user {
+		t.Errorf("Expected the new token to be for the same user, got '%s'", err)
+	} else if data.Id != token.Id || !data.Created.Equal(token.Created.Add(-time.Hour)) {
+		t.Errorf("Expected the new token to continue session %s, got session %s created at %s", token.Id, data.Id, data.Created)
+	}
+	if _, err := sliding.RefreshToken(token.Token, ""); !errorMessages.Matches(err, errorMessages.ErrTokenInvalid) {
+		t.Errorf(`Expected "ErrTokenInvalid" error refreshing the old token again, got '%s'`, err)
+	}
+
+	// Refreshing cannot extend the session beyond the maximum lifetime.
+	for i := 0; i < 3; i++ {
+		if refreshed, err = sliding.RefreshToken(refreshed.Token, ""); err != nil {
+			t.Fatalf("Expected no error refreshing the token again, got '%s'", err)
+		}
+	}
+	if expected := token.Created.Add(-time.Hour).Add(90 * time.Minute); !refreshed.Expiry.Equal(expected) {
+		t.Errorf("Expected the refreshed token to expire at the maximum lifetime %s, got %s", expected, refreshed.Expiry)
+	}
+}
+
+func TestTokenManagerSessions(t *testing.T) {
+	user := users.NewUser("Steve", "steve@test.com", users.StandardUser())
+	other := users.NewUser("Alice", "alice@test.com", users.StandardUser())
+
+	tm := NewTokenManager(time.Hour, 0)
+	first, _ := tm.CreateToken(&user, "192.0.2.1")
+	second, _ := tm.CreateToken(&user, "192.0.2.2")
+	tm.CreateToken(&other, "192.0.2.3")
+
+	sessions := tm.Sessions(user.Uuid)
+	if len(sessions) != 2 || sessions[0].Id != first.Id || sessions[1].Id != second.Id {
+		t.Fatalf("Expected the 2 sessions of the user oldest first, got %+v", sessions)
+	}
+	if sessions[0].Token != "" || sessions[0].IP != "192.0.2.1" {
+		t.Errorf("Expected the session with its IP address but without its token, got %+v", sessions[0])
+	}
+
+	if err := tm.DeleteSession(other.Uuid, first.Id); !errorMessages.Matches(err, errorMessages.ErrSessionNotFound) {
+		t.Errorf(`Expected "ErrSessionNotFound" error deleting the session of another user, got '%s'`, err)
+	}
+	if err := tm.DeleteSession(user.Uuid, first.Id); err != nil {
+		t.Errorf("Expected no error deleting the session, got '%s'", err)
+	}
+	if _, err := tm.GetToken(first.Token); !errorMessages.Matches(err, errorMessages.ErrTokenInvalid) {
+		t.Errorf(`Expected "ErrTokenInvalid" error getting the token of the deleted session, got '%s'`, err)
+	}
+
+	if deleted := tm.DeleteSessions(user.Uuid); deleted != 1 {
+		t.Errorf("Expected 1 session deleted, got %d", deleted)
+	}
+	if sessions := tm.Sessions(other.Uuid); len(sessions) != 1 {
+		t.Errorf("Expected the session of the other user to be kept, got %d", len(sessions))
+	}
+}
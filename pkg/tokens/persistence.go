@@ -0,0 +1,81 @@
+package tokens
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+// SavedToken is a token as persisted, identified by its hash; the raw token is never saved.
+type SavedToken struct {
+	Hash    string    `json:"hash" doc:"The SHA-256 hash of the token."`
+	Id      string    `json:"id" doc:"The identifier of the session."`
+	User    uuid.UUID `json:"user" doc:"The unique identifier of the user the token is for."`
+	Created time.Time `json:"created" doc:"The time the token was created."`
+	Expiry  time.Time `json:"expiry" doc:"The time the token expires."`
+	IP      string    `json:"ip,omitempty" doc:"The IP address the session was created from."`
+}
+
+// Save returns the unexpired tokens, so that they can be restored after a restart.
+func (tm *TokenManager) Save() []SavedToken {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	now := time.Now()
+	saved := make([]SavedToken, 0, len(tm.tokens))
+	for hash, data := range tm.tokens {
+		if data.Expiry.After(now) {
+			saved = append(saved, SavedToken{
+				Hash:    hash,
+				Id:      data.Id,
+				User:    data.User.Uuid,
+				Created: data.Created,
+				Expiry:  data.Expiry,
+				IP:      data.IP,
+			})
+		}
+	}
+
+	return saved
+}
+
+// Restore adds the saved tokens, pointing each to the user with its UUID as found by `lookup`,
+// returning the number of tokens restored.
+//
+// Tokens that had expired, or whose user no longer exists, are discarded.
+func (tm *TokenManager) Restore(saved []SavedToken, lookup func(uuid.UUID) (*users.User, bool)) int {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	now := time.Now()
+	restored := 0
+	for _, token := range saved {
+		if !token.Expiry.After(now) {
+			continue
+		}
+		if user, ok := lookup(token.User); ok {
+			// Tokens saved before their creation time was recorded could not have slid.
+			created := token.Created
+			if created.IsZero() {
+				created = token.Expiry.Add(-tm.expiration)
+			}
+			// Tokens saved before sessions were identified get a new identifier.
+			id := token.Id
+			if id == "" {
+				id = uuid.NewString()
+			}
+			tm.tokens[token.Hash] = TokenData{
+				Id:      id,
+				User:    user,
+				Created: created,
+				Expiry:  token.Expiry,
+				IP:      token.IP,
+			}
+			restored++
+		}
+	}
+
+	return restored
+}
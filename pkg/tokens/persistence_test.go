@@ -0,0 +1,59 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/denwong47/pigeon-hole/pkg/users"
+)
+
+func TestTokenManagerPersistence(t *testing.T) {
+	user := users.NewUser("Steve", "steve@test.com", users.StandardUser())
+	removed := users.NewUser("Alice", "alice@test.com", users.StandardUser())
+
+	tm := NewTokenManager(time.Hour, 0)
+	token, _ := tm.CreateToken(&user, "")
+	tm.CreateToken(&removed, "")
+
+	saved := tm.Save()
+	if len(saved) != 2 {
+		t.Fatalf("Expected 2 saved tokens, got %d", len(saved))
+	}
+	for _, savedToken := range saved {
+		if savedToken.Hash == token.Token {
+			t.Errorf("Expected the raw token not to be saved")
+		}
+	}
+
+	// The restored token points to the user reloaded from the list, not the original.
+	reloaded := user
+	restoredTm := NewTokenManager(time.Hour, 0)
+	restored := restoredTm.Restore(saved, func(id uuid.UUID) (*users.User, bool) {
+		if id == reloaded.Uuid {
+			return &reloaded, true
+		}
+		return nil, false
+	})
+	if restored != 1 {
+		t.Errorf("Expected 1 token restored without the removed user, got %d", restored)
+	}
+
+	if data, err := restoredTm.GetToken(token.Token); err != nil {
+		t.Errorf("Expected no error getting the restored token, got '%s'", err)
+	} else if data.User != &reloaded {
+		t.Errorf("Expected the restored token to point to the reloaded user")
+	}
+
+	// Expired tokens are neither saved nor restored.
+	expired := NewTokenManager(-time.Minute, 0)
+	expired.CreateToken(&user, "")
+	if saved := expired.Save(); len(saved) != 0 {
+		t.Errorf("Expected no expired tokens to be saved, got %d", len(saved))
+	}
+	saved[0].Expiry = time.Now().Add(-time.Minute)
+	if restored := restoredTm.Restore(saved[:1], func(uuid.UUID) (*users.User, bool) { return &user, true }); restored != 0 {
+		t.Errorf("Expected no expired tokens to be restored, got %d", restored)
+	}
+}
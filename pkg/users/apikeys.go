@@ -0,0 +1,32 @@
+package users
+
+import (
+	"time"
+)
+
+// APIKey is a long-lived key that a user can authenticate with instead of a token, so that
+// automation does not need to store their password.
+//
+// Only the hash of the key is kept; the key itself is only known when it is created.
+type APIKey struct {
+	Name       string      `json:"name" doc:"The name of the key, unique for the user."`
+	Hash       string      `json:"hash" doc:"The SHA-256 hash of the key."`
+	Created    time.Time   `json:"created" doc:"The time the key was created."`
+	Expiry     *time.Time  `json:"expiry,omitempty" doc:"The time the key expires; it never expires if not set."`
+	Privileges *Privileges `json:"privileges,omitempty" doc:"The privileges the key is restricted to, within those of the user; those of the user if not set."`
+}
+
+// Returns `true` if the key has expired at the time.
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.Expiry != nil && !k.Expiry.After(now)
+}
+
+// Find the API key of the User by its name.
+func (u *User) GetAPIKey(name string) (*APIKey, bool) {
+	for i := range u.APIKeys {
+		if u.APIKeys[i].Name == name {
+			return &u.APIKeys[i], true
+		}
+	}
+	return nil, false
+}
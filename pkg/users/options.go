@@ -4,6 +4,10 @@ import "time"
 
 // UserOptions is the struct that contains options for user related operations.
 type UserOptions struct {
-	Salt            string        `json:"salt" doc:"The salt used to hash the user's password."`
-	TokenExpiration time.Duration `json:"tokenExpiration" doc:"The duration that a token is valid for."`
+	Salt             string        `json:"salt" doc:"The salt used to check the user's password, if it was hashed before per-password salts were introduced."`
+	HashAlgorithm    HashAlgorithm `json:"hashAlgorithm" doc:"The algorithm used to hash the user's password."`
+	TokenExpiration  time.Duration `json:"tokenExpiration" doc:"The duration that a token is valid for."`
+	TokenMaxLifetime time.Duration `json:"tokenMaxLifetime" doc:"The duration beyond which a token cannot be extended by using it; the expiry of tokens does not slide if this is zero."`
+	PersistTokens    bool          `json:"persistTokens" doc:"Whether the hashes of unexpired tokens are written to the user list, so that they survive a restart."`
+	Roles            Roles         `json:"roles" doc:"The roles that users can be added with."`
 }
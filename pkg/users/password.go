@@ -1,28 +1,160 @@
 package users
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
-	"slices"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
 	"strings"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// HashAlgorithm is the algorithm used to hash the passwords of users.
+type HashAlgorithm string
+
+const (
+	// Hash passwords with argon2id; this is the default.
+	HashArgon2id HashAlgorithm = "argon2id"
+	// Hash passwords with bcrypt, which limits passwords to 72 bytes.
+	HashBcrypt HashAlgorithm = "bcrypt"
+	// The unsalted SHA-256 hashes of older user lists; these are only ever checked, never generated.
+	hashLegacy HashAlgorithm = "sha256"
+)
+
+// The parameters of argon2id, as recommended by RFC 9106 for memory constrained environments.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
 )
 
-// Generate the Hashed password for a user.
-func genHashedPass(uuid uuid.UUID, password string, options *UserOptions) []byte {
+// Parse a `HashAlgorithm` from its name.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch algorithm := HashAlgorithm(name); algorithm {
+	case HashArgon2id, HashBcrypt:
+		return algorithm, nil
+	case "":
+		return HashArgon2id, nil
+	default:
+		return "", errorMessages.ErrUnknownHashAlgorithm
+	}
+}
+
+// Identify the algorithm of a hashed password from its tag.
+//
+// Hashes are stored in the PHC string format, e.g. `$argon2id$v=19$...`, or the modular crypt
+// format of bcrypt, e.g. `$2a$10$...`; anything else is a legacy SHA-256 hash.
+func algorithmOf(hashed []byte) HashAlgorithm {
+	switch {
+	case bytes.HasPrefix(hashed, []byte("$argon2id$")):
+		return HashArgon2id
+	case bytes.HasPrefix(hashed, []byte("$2a$")), bytes.HasPrefix(hashed, []byte("$2b$")), bytes.HasPrefix(hashed, []byte("$2y$")):
+		return HashBcrypt
+	default:
+		return hashLegacy
+	}
+}
+
+// Generate the legacy SHA-256 hashed password for a user.
+func genLegacyHashedPass(uuid uuid.UUID, password string, options *UserOptions) []byte {
 	hash := sha256.New()
 	salted := strings.Join([]string{uuid.String(), password, options.Salt}, "|")
 	hash.Write([]byte(salted))
 	return hash.Sum(nil)
 }
 
+// Generate the hashed password with the algorithm in the options, tagged with the algorithm.
+func genHashedPass(password string, options *UserOptions) ([]byte, error) {
+	algorithm, err := ParseHashAlgorithm(string(options.HashAlgorithm))
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case HashBcrypt:
+		return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	default:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return []byte(fmt.Sprintf(
+			"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version,
+			argon2Memory,
+			argon2Time,
+			argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)), nil
+	}
+}
+
+// Check the password against an argon2id hash, using the parameters stored in the hash.
+func checkArgon2id(hashed []byte, password string) bool {
+	parts := strings.Split(string(hashed), "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	} else if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, candidate) == 1
+}
+
 // Set the password for a user.
-func (u User) SetPassword(password string, options *UserOptions) User {
-	u.HashedPass = genHashedPass(u.Uuid, password, options)
-	return u
+func (u User) SetPassword(password string, options *UserOptions) (User, error) {
+	hashed, err := genHashedPass(password, options)
+	if err != nil {
+		return u, err
+	}
+
+	u.HashedPass = hashed
+	return u, nil
 }
 
 // Check if the password is correct for a user.
 func (u User) CheckPassword(password string, options *UserOptions) bool {
-	return slices.Equal(u.HashedPass, genHashedPass(u.Uuid, password, options))
+	switch algorithmOf(u.HashedPass) {
+	case HashArgon2id:
+		return checkArgon2id(u.HashedPass, password)
+	case HashBcrypt:
+		return bcrypt.CompareHashAndPassword(u.HashedPass, []byte(password)) == nil
+	default:
+		return subtle.ConstantTimeCompare(u.HashedPass, genLegacyHashedPass(u.Uuid, password, options)) == 1
+	}
+}
+
+// Returns `true` if the password of the user is not hashed with the algorithm in the options,
+// e.g. a legacy SHA-256 hash, and should be hashed again on the next successful login.
+func (u User) NeedsRehash(options *UserOptions) bool {
+	algorithm, err := ParseHashAlgorithm(string(options.HashAlgorithm))
+	return err == nil && algorithmOf(u.HashedPass) != algorithm
 }
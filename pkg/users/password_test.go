@@ -0,0 +1,57 @@
+package users
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	for _, algorithm := range []HashAlgorithm{HashArgon2id, HashBcrypt} {
+		options := UserOptions{Salt: "salt", HashAlgorithm: algorithm}
+		user, err := NewUser("Steve", "steve@test.com", StandardUser()).SetPassword("password123", &options)
+		if err != nil {
+			t.Fatalf("Expected no error setting a %s password, got '%s'", algorithm, err)
+		}
+
+		if algorithmOf(user.HashedPass) != algorithm {
+			t.Errorf("Expected the hash to be tagged with %s, got '%s'", algorithm, user.HashedPass)
+		}
+		if !user.CheckPassword("password123", &options) {
+			t.Errorf("Expected the %s password to match", algorithm)
+		}
+		if user.CheckPassword("password124", &options) {
+			t.Errorf("Expected a wrong %s password not to match", algorithm)
+		}
+		if user.NeedsRehash(&options) {
+			t.Errorf("Expected a %s password not to need rehashing", algorithm)
+		}
+	}
+
+	// Each password has its own salt.
+	options := UserOptions{Salt: "salt"}
+	first, _ := NewUser("Steve", "steve@test.com", StandardUser()).SetPassword("password123", &options)
+	second, _ := first.SetPassword("password123", &options)
+	if bytes.Equal(first.HashedPass, second.HashedPass) {
+		t.Errorf("Expected the same password to be hashed differently each time")
+	}
+
+	// Hashes of older user lists are checked, and need rehashing.
+	legacy := NewUser("Steve", "steve@test.com", StandardUser())
+	legacy.HashedPass = genLegacyHashedPass(legacy.Uuid, "password123", &options)
+	if !legacy.CheckPassword("password123", &options) {
+		t.Errorf("Expected the legacy password to match")
+	}
+	if legacy.CheckPassword("password123", &UserOptions{Salt: "pepper"}) {
+		t.Errorf("Expected the legacy password not to match with another salt")
+	}
+	if !legacy.NeedsRehash(&options) {
+		t.Errorf("Expected the legacy password to need rehashing")
+	}
+	if !first.NeedsRehash(&UserOptions{HashAlgorithm: HashBcrypt}) {
+		t.Errorf("Expected an argon2id password to need rehashing when using bcrypt")
+	}
+
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Errorf("Expected an error parsing an unknown algorithm")
+	}
+}
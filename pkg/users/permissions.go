@@ -1,6 +1,11 @@
 package users
 
-import errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
 
 // Permissions is a struct that represents the permissions that a user has on an object.
 type Permissions struct {
@@ -10,10 +15,97 @@ type Permissions struct {
 	Delete bool `json:"delete" doc:"Whether the user can delete the object."`
 }
 
+// Returns the permissions granted by both.
+func (p Permissions) And(other Permissions) Permissions {
+	return Permissions{
+		Select: p.Select && other.Select,
+		Insert: p.Insert && other.Insert,
+		Update: p.Update && other.Update,
+		Delete: p.Delete && other.Delete,
+	}
+}
+
 // Privileges is a struct that represents the permissions that a user has on objects they own and all objects.
+//
+// If `Scopes` is not empty, the privileges only apply to the keys starting with one of its
+// prefixes; the user has no privileges on any other key. Within the scopes, the `Rules` with
+// the longest prefix of a key replace `Owned` and `All` for that key.
 type Privileges struct {
-	Owned Permissions `json:"owned" doc:"The permissions the user has on objects they own."`
-	All   Permissions `json:"all" doc:"The permissions the user has on all objects."`
+	Owned  Permissions  `json:"owned" doc:"The permissions the user has on objects they own."`
+	All    Permissions  `json:"all" doc:"The permissions the user has on all objects."`
+	Scopes []string     `json:"scopes,omitempty" doc:"The key prefixes these privileges are limited to; all keys if empty."`
+	Rules  []PrefixRule `json:"rules,omitempty" doc:"The permissions for keys with specific prefixes, which replace 'owned' and 'all' for those keys."`
+}
+
+// PrefixRule replaces the permissions of a user on the keys starting with its prefix.
+type PrefixRule struct {
+	Prefix string      `json:"prefix" doc:"The prefix of the keys this rule applies to."`
+	Owned  Permissions `json:"owned" doc:"The permissions the user has on objects they own with this prefix."`
+	All    Permissions `json:"all" doc:"The permissions the user has on all objects with this prefix."`
+}
+
+// Find the permissions that apply to the key, i.e. those of the rule with the longest
+// matching prefix, or the default permissions if no rule matches.
+//
+// There are no permissions at all for keys outside the scopes.
+func (p *Privileges) ForKey(key string) PrefixRule {
+	if !p.InScope(key) {
+		return PrefixRule{}
+	}
+
+	effective := PrefixRule{Owned: p.Owned, All: p.All}
+	for _, rule := range p.Rules {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) >= len(effective.Prefix) {
+			effective = rule
+		}
+	}
+	return effective
+}
+
+// List the rules in the order they are matched, i.e. longest prefix first, ending with the
+// default permissions as a rule with an empty prefix.
+func (p *Privileges) EffectiveRules() []PrefixRule {
+	rules := slices.Clone(p.Rules)
+	slices.SortStableFunc(rules, func(a, b PrefixRule) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+
+	return append(rules, PrefixRule{Owned: p.Owned, All: p.All})
+}
+
+// Check that the privileges are well formed.
+func (p *Privileges) Validate() error {
+	for _, scope := range p.Scopes {
+		if scope == "" {
+			return errors.New("has an empty scope; omit the scopes to allow all keys")
+		}
+	}
+
+	prefixes := make([]string, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		if rule.Prefix == "" {
+			return errors.New("has a rule without a prefix; use 'owned' and 'all' instead")
+		} else if slices.Contains(prefixes, rule.Prefix) {
+			return fmt.Errorf("has more than one rule for prefix '%s'", rule.Prefix)
+		}
+		prefixes = append(prefixes, rule.Prefix)
+	}
+
+	return nil
+}
+
+// Returns `true` if the privileges apply to the key.
+func (p *Privileges) InScope(key string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range p.Scopes {
+		if strings.HasPrefix(key, scope) {
+			return true
+		}
+	}
+	return false
 }
 
 const (
@@ -91,16 +183,9 @@ func AdminUser() Privileges {
 }
 
 // Helper function to get the Privileges for a given UserType.
+//
+// This only knows the built-in user types; use `Roles.Privileges` for the roles configured at
+// runtime.
 func GetPrivilegesByType(userType string) (Privileges, error) {
-	switch userType {
-	case AdminUserType:
-		return AdminUser(), nil
-	case StandardUserType:
-		return StandardUser(), nil
-	case RestrictedUserType:
-		return RestrictedUser(), nil
-	default:
-		// No privileges for unknown user types.
-		return Privileges{}, errorMessages.ErrUnknownUserType
-	}
+	return DefaultRoles().Privileges(userType)
 }
@@ -0,0 +1,63 @@
+package users
+
+import (
+	"testing"
+)
+
+func TestPrivilegesForKey(t *testing.T) {
+	privileges := Privileges{
+		Owned:  ReadOnlyPermissions(),
+		All:    NoPermissions(),
+		Scopes: []string{"team/"},
+		Rules: []PrefixRule{
+			{Prefix: "team/", Owned: FullPermissions(), All: ReadOnlyPermissions()},
+			{Prefix: "team/archive/", Owned: ReadOnlyPermissions(), All: NoPermissions()},
+		},
+	}
+
+	if rule := privileges.ForKey("team/archive/old"); rule.Prefix != "team/archive/" {
+		t.Errorf("Expected the longest prefix 'team/archive/' to win, got '%s'", rule.Prefix)
+	}
+	if rule := privileges.ForKey("team/new"); rule.Prefix != "team/" || !rule.Owned.Update {
+		t.Errorf("Expected the rule for 'team/', got %+v", rule)
+	}
+	if rule := privileges.ForKey("other/new"); rule.Owned.Select || rule.All.Select {
+		t.Errorf("Expected no permissions outside the scopes, got %+v", rule)
+	}
+
+	user := NewUser("Steve", "steve@test.com", privileges)
+	if !user.CanUpdate("team/new", true) || user.CanUpdate("team/archive/old", true) || user.CanSelect("other/new", true) {
+		t.Errorf("Expected the permissions of the matching rules to apply")
+	}
+
+	rules := privileges.EffectiveRules()
+	if len(rules) != 3 || rules[0].Prefix != "team/archive/" || rules[1].Prefix != "team/" || rules[2].Prefix != "" {
+		t.Errorf("Expected the rules longest prefix first, ending with the default, got %+v", rules)
+	}
+	if rules[2].Owned != privileges.Owned {
+		t.Errorf("Expected the default rule to have the default permissions, got %+v", rules[2])
+	}
+}
+
+func TestUserRestriction(t *testing.T) {
+	user := NewUser("Steve", "steve@test.com", StandardUser())
+	restriction := Privileges{
+		Owned:  Permissions{Select: true, Insert: true},
+		All:    FullPermissions(),
+		Scopes: []string{"ci/"},
+	}
+	user.Restriction = &restriction
+
+	if !user.CanInsert("ci/build", true) || user.CanUpdate("ci/build", true) {
+		t.Errorf("Expected only the permissions of both the user and the restriction to apply")
+	}
+	if user.CanUpdate("ci/build", false) {
+		t.Errorf("Expected the restriction not to grant permissions the user does not have")
+	}
+	if user.InScope("other") || user.CanSelect("other", true) {
+		t.Errorf("Expected no permissions outside the scopes of the restriction")
+	}
+	if user.CanUpdateAny() {
+		t.Errorf("Expected the user not to be able to update objects of others")
+	}
+}
@@ -0,0 +1,93 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+// The pattern that the names of roles have to match.
+var ROLE_NAME_PATTERN = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Roles are the named sets of privileges that users can be added with.
+type Roles map[string]Privileges
+
+// The built-in roles, which are available unless overridden by the configuration.
+func DefaultRoles() Roles {
+	return Roles{
+		AdminUserType:      AdminUser(),
+		StandardUserType:   StandardUser(),
+		RestrictedUserType: RestrictedUser(),
+	}
+}
+
+// Get the privileges of the role.
+func (r Roles) Privileges(name string) (Privileges, error) {
+	if privileges, ok := r[name]; ok {
+		// Do not share the scopes and rules between users of the same role.
+		privileges.Scopes = slices.Clone(privileges.Scopes)
+		privileges.Rules = slices.Clone(privileges.Rules)
+		return privileges, nil
+	}
+
+	// No privileges for unknown roles.
+	return Privileges{}, errorMessages.ErrUnknownUserType
+}
+
+// List the names of the roles, sorted.
+func (r Roles) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Check that all the roles are well formed.
+func (r Roles) Validate() error {
+	for name, privileges := range r {
+		if !ROLE_NAME_PATTERN.MatchString(name) {
+			return fmt.Errorf("%w: role name '%s' must match %s", errorMessages.ErrInvalidRole, name, ROLE_NAME_PATTERN)
+		}
+		if err := privileges.Validate(); err != nil {
+			return fmt.Errorf("%w: role '%s' %s", errorMessages.ErrInvalidRole, name, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadRoles reads the roles from a JSON file, in addition to the built-in roles.
+//
+// The file is an object of role names to privileges; roles with the same name as a built-in
+// role replace it. Unknown fields are rejected, so that typos do not silently grant nothing.
+func LoadRoles(path string) (Roles, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errorMessages.ErrRoleFileNotFound
+	}
+
+	var configured Roles
+	decoder := json.NewDecoder(bytes.NewReader(buffer))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configured); err != nil {
+		return nil, fmt.Errorf("%w: %s", errorMessages.ErrInvalidRole, err)
+	}
+	if err := configured.Validate(); err != nil {
+		return nil, err
+	}
+
+	roles := DefaultRoles()
+	for name, privileges := range configured {
+		roles[name] = privileges
+	}
+
+	return roles, nil
+}
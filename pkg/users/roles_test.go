@@ -0,0 +1,46 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	errorMessages "github.com/denwong47/pigeon-hole/pkg/errors"
+)
+
+func TestRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+
+	os.WriteFile(path, []byte(`{"auditor": {"owned": {"read": true}, "all": {"read": true}, "scopes": ["audit/"]}, "restricted": {"owned": {"read": true}, "all": {}}}`), 0600)
+	roles, err := LoadRoles(path)
+	if err != nil {
+		t.Fatalf(`Expected no error, got '%s'`, err)
+	}
+	if names := roles.Names(); !slices.Equal(names, []string{"admin", "auditor", "restricted", "standard"}) {
+		t.Errorf("Expected the built-in and configured roles, got %v", names)
+	}
+	if privileges, _ := roles.Privileges("restricted"); privileges.Owned.Update {
+		t.Errorf("Expected the configured role to replace the built-in role, got %+v", privileges)
+	}
+	if privileges, _ := roles.Privileges("auditor"); !privileges.InScope("audit/log") || privileges.InScope("mySecret") {
+		t.Errorf("Expected the auditor to be scoped to 'audit/', got %+v", privileges.Scopes)
+	}
+	if _, err := roles.Privileges("unknown"); !errorMessages.Matches(err, errorMessages.ErrUnknownUserType) {
+		t.Errorf(`Expected "ErrUnknownUserType" error, got '%s'`, err)
+	}
+
+	// Typos and invalid names should be rejected
+	for _, content := range []string{
+		`{"auditor": {"owned": {"select": true}}}`,
+		`{"Auditor!": {"owned": {"read": true}}}`,
+		`{"auditor": {"scopes": [""]}}`,
+		`{"auditor": {"rules": [{"prefix": ""}]}}`,
+		`{"auditor": {"rules": [{"prefix": "a/"}, {"prefix": "a/"}]}}`,
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadRoles(path); !errorMessages.Matches(err, errorMessages.ErrInvalidRole) {
+			t.Errorf(`Expected "ErrInvalidRole" error for %s, got '%v'`, content, err)
+		}
+	}
+}
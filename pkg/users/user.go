@@ -1,6 +1,7 @@
 package users
 
 import (
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
@@ -8,11 +9,18 @@ import (
 
 // User is the struct that represents a user in the system.
 type User struct {
-	Uuid       uuid.UUID  `json:"uuid" doc:"The unique identifier for the user. Currently unused."`
-	Name       string     `json:"name" doc:"The name of the user."`
-	Email      string     `json:"email" doc:"The email of the user. No emails will be sent; this is used as an unique identifier only."`
-	HashedPass []byte     `json:"hashedPass" doc:"The hashed password of the user."`
-	Privileges Privileges `json:"privileges" doc:"The privileges that this user has on objects."`
+	Uuid       uuid.UUID   `json:"uuid" doc:"The unique identifier for the user; this identifies the owner of objects."`
+	Name       string      `json:"name" doc:"The name of the user."`
+	Email      string      `json:"email" doc:"The email of the user. No emails will be sent; this is used as an unique identifier only."`
+	HashedPass []byte      `json:"hashedPass" doc:"The hashed password of the user."`
+	Privileges Privileges  `json:"privileges" doc:"The privileges that this user has on objects."`
+	Role       string      `json:"role,omitempty" doc:"The role the privileges of this user were taken from when they were added."`
+	Groups     []uuid.UUID `json:"groups,omitempty" doc:"The unique identifiers of the groups this user is a member of."`
+	APIKeys    []APIKey    `json:"apiKeys,omitempty" doc:"The long-lived keys the user can authenticate with instead of a token."`
+
+	// The privileges of the API key the user authenticated with, if any, which further restrict
+	// their own; this only exists for the duration of a request.
+	Restriction *Privileges `json:"-"`
 }
 
 // New creates a new user with a new UUID and the specified privileges.
@@ -37,24 +45,63 @@ func UserWithUuid(uuid uuid.UUID, name string, email string, privileges Privileg
 	}
 }
 
-// Returns `true` if the User is allowed to read objects.
-func (u *User) CanSelect(isOwner bool) bool {
-	return u.Privileges.All.Select || (isOwner && u.Privileges.Owned.Select)
+// Returns `true` if the User is a member of the group.
+func (u *User) IsMemberOf(group uuid.UUID) bool {
+	return slices.Contains(u.Groups, group)
+}
+
+// Find the permissions of the User that apply to the key, taking any restriction into account.
+func (u *User) ForKey(key string) PrefixRule {
+	rule := u.Privileges.ForKey(key)
+	if u.Restriction != nil {
+		restriction := u.Restriction.ForKey(key)
+		rule.Owned = rule.Owned.And(restriction.Owned)
+		rule.All = rule.All.And(restriction.All)
+	}
+	return rule
+}
+
+// Find the permissions that the restriction of the User, if any, leaves them on objects they own
+// at the key; this is all permissions if the User is not restricted.
+func (u *User) RestrictedOwned(key string) Permissions {
+	if u.Restriction == nil {
+		return FullPermissions()
+	}
+	return u.Restriction.ForKey(key).Owned
+}
+
+// Returns `true` if the privileges of the User apply to the key, taking any restriction into account.
+func (u *User) InScope(key string) bool {
+	return u.Privileges.InScope(key) && (u.Restriction == nil || u.Restriction.InScope(key))
+}
+
+// Returns `true` if the User is allowed to update objects they do not own, at least for some keys.
+func (u *User) CanUpdateAny() bool {
+	return u.Privileges.All.Update && (u.Restriction == nil || u.Restriction.All.Update)
+}
+
+// Returns `true` if the User is allowed to read the object at the key.
+func (u *User) CanSelect(key string, isOwner bool) bool {
+	rule := u.ForKey(key)
+	return rule.All.Select || (isOwner && rule.Owned.Select)
 }
 
-// Returns `true` if the User is allowed insert objects.
+// Returns `true` if the User is allowed insert an object at the key.
 // This supports the `isOwner` flag, but typically only the `Owned` privileges are
 // since there is no mechanism for a user to insert data other than their own.
-func (u *User) CanInsert(isOwner bool) bool {
-	return u.Privileges.All.Insert || (isOwner && u.Privileges.Owned.Insert)
+func (u *User) CanInsert(key string, isOwner bool) bool {
+	rule := u.ForKey(key)
+	return rule.All.Insert || (isOwner && rule.Owned.Insert)
 }
 
-// Returns `true` if the User is allowed to update objects.
-func (u *User) CanUpdate(isOwner bool) bool {
-	return u.Privileges.All.Update || (isOwner && u.Privileges.Owned.Update)
+// Returns `true` if the User is allowed to update the object at the key.
+func (u *User) CanUpdate(key string, isOwner bool) bool {
+	rule := u.ForKey(key)
+	return rule.All.Update || (isOwner && rule.Owned.Update)
 }
 
-// Returns `true` if the User is allowed to delete objects.
-func (u *User) CanDelete(isOwner bool) bool {
-	return u.Privileges.All.Delete || (isOwner && u.Privileges.Owned.Delete)
+// Returns `true` if the User is allowed to delete the object at the key.
+func (u *User) CanDelete(key string, isOwner bool) bool {
+	rule := u.ForKey(key)
+	return rule.All.Delete || (isOwner && rule.Owned.Delete)
 }